@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var errPageTooLarge = errors.New("page too large")
+
+// downloadInterval is the minimum time between progress updates of a download
+const downloadInterval = time.Millisecond * 250
+
+type downloadUpdate struct {
+	written int64
+	err     error
+	done    bool
+}
+
+// downloadCount numbers the downloads to tell them apart
+var downloadCount uint64
+
+// DownloadEvent reports the progress of a download that runs in the background
+type DownloadEvent struct {
+	tab     tabID
+	id      uint64
+	path    string
+	update  downloadUpdate
+	updates <-chan downloadUpdate
+}
+
+func (de DownloadEvent) Tab() tabID {
+	return de.tab
+}
+
+// createDownloadFile creates a new file at path, or at path with a number added when it exists
+// Creating the file right away reserves its name, so downloads that start together get different files.
+func createDownloadFile(path string) (*os.File, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	name := path
+	for count := 1; ; count++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsExist(err) || count > 100 {
+			return nil, fmt.Errorf("could not create download file: %w", err)
+		}
+		name = fmt.Sprintf("%s_%d%s", base, count, ext)
+	}
+}
+
+// copyToFile copies body to f in the background, f is closed when done and removed when the copy fails
+// The returned channel holds the latest progress, the last update is marked done.
+func copyToFile(body io.ReadCloser, f *os.File) <-chan downloadUpdate {
+	updates := make(chan downloadUpdate, 1)
+	send := func(u downloadUpdate) {
+		select {
+		case updates <- u:
+		default: // Replace the update nobody has seen yet
+			select {
+			case <-updates:
+			default:
+			}
+			updates <- u
+		}
+	}
+	go func() {
+		defer close(updates)
+		defer body.Close()
+		var err error
+		var written int64
+		buf := make([]byte, 32*1024)
+		for {
+			n, rerr := body.Read(buf)
+			if n > 0 {
+				if _, err = f.Write(buf[:n]); err != nil {
+					break
+				}
+				written += int64(n)
+				send(downloadUpdate{written: written})
+			}
+			if rerr != nil {
+				if rerr != io.EOF {
+					err = rerr
+				}
+				break
+			}
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			err = fmt.Errorf("could not complete download: %w", err)
+		}
+		send(downloadUpdate{written: written, err: err, done: true})
+	}()
+	return updates
+}
+
+func waitForDownload(ev DownloadEvent) tea.Cmd {
+	return func() tea.Msg {
+		time.Sleep(downloadInterval)
+		u, ok := <-ev.updates
+		if !ok {
+			u = downloadUpdate{written: ev.update.written, done: true}
+		}
+		ev.update = u
+		return ev
+	}
+}
+
+// readPage reads at most max bytes of body, reading is aborted when ctx is done
+func readPage(ctx context.Context, body io.ReadCloser, max int64) ([]byte, error) {
+	defer body.Close()
+	go func() {
+		<-ctx.Done()
+		body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, errPageTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"git.sr.ht/~rafael/gembro/text"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/muesli/termenv"
+)
+
+// maxURLLength is the maximum length in bytes of the URL of a Gemini request
+const maxURLLength = 1024
+
+// Editor is a multi-line input for replies to status 10 prompts
+// The text is sent as the query of the URL in Payload.
+type Editor struct {
+	Message string
+	Type    int
+	Payload string
+	lines   [][]rune
+	row     int
+	col     int
+	top     int // first visible line
+	height  int
+	tab     tabID
+	command string // external editor
+}
+
+func NewEditor(tab tabID, command string) Editor {
+	return Editor{tab: tab, command: command, lines: [][]rune{nil}}
+}
+
+func (e Editor) Show(msg, val, payload string, typ, height int) Editor {
+	e.Message = text.Wrap(msg, 80)
+	e.Payload = payload
+	e.Type = typ
+	e.height = height - strings.Count(e.Message, "\n") - 5
+	if e.height < 3 {
+		e.height = 3
+	}
+	e = e.SetValue(val)
+	return e
+}
+
+func (e Editor) SetValue(val string) Editor {
+	e.lines = nil
+	for _, line := range strings.Split(val, "\n") {
+		e.lines = append(e.lines, []rune(line))
+	}
+	e.row = len(e.lines) - 1
+	e.col = len(e.lines[e.row])
+	e.top = 0
+	e.scroll()
+	return e
+}
+
+func (e Editor) Value() string {
+	lines := make([]string, len(e.lines))
+	for i, line := range e.lines {
+		lines[i] = string(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// remaining returns how many bytes are left for the request with the current text
+func (e Editor) remaining() int {
+	return maxURLLength - len(e.Payload) - len("?") - len(neturl.QueryEscape(e.Value()))
+}
+
+func (e *Editor) scroll() {
+	if e.row < e.top {
+		e.top = e.row
+	}
+	if e.row >= e.top+e.height {
+		e.top = e.row - e.height + 1
+	}
+}
+
+func (e Editor) Update(msg tea.Msg) (Editor, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		line := e.lines[e.row]
+		switch msg.Type {
+		case tea.KeyCtrlD:
+			if e.remaining() < 0 {
+				return e, nil
+			}
+			return e, fireEvent(InputEvent{Value: e.Value(), Type: e.Type, Payload: e.Payload})
+		case tea.KeyEsc:
+			return e, fireEvent(CloseInputEvent{})
+		case tea.KeyCtrlO:
+			return e, e.openExternal()
+		case tea.KeyEnter:
+			rest := append([]rune(nil), line[e.col:]...)
+			e.lines[e.row] = line[:e.col]
+			e.lines = append(e.lines[:e.row+1], append([][]rune{rest}, e.lines[e.row+1:]...)...)
+			e.row++
+			e.col = 0
+		case tea.KeyBackspace:
+			if e.col > 0 {
+				e.lines[e.row] = append(line[:e.col-1], line[e.col:]...)
+				e.col--
+			} else if e.row > 0 {
+				e.col = len(e.lines[e.row-1])
+				e.lines[e.row-1] = append(e.lines[e.row-1], line...)
+				e.lines = append(e.lines[:e.row], e.lines[e.row+1:]...)
+				e.row--
+			}
+		case tea.KeyDelete:
+			if e.col < len(line) {
+				e.lines[e.row] = append(line[:e.col], line[e.col+1:]...)
+			} else if e.row < len(e.lines)-1 {
+				e.lines[e.row] = append(line, e.lines[e.row+1]...)
+				e.lines = append(e.lines[:e.row+1], e.lines[e.row+2:]...)
+			}
+		case tea.KeyLeft:
+			if e.col > 0 {
+				e.col--
+			} else if e.row > 0 {
+				e.row--
+				e.col = len(e.lines[e.row])
+			}
+		case tea.KeyRight:
+			if e.col < len(line) {
+				e.col++
+			} else if e.row < len(e.lines)-1 {
+				e.row++
+				e.col = 0
+			}
+		case tea.KeyUp:
+			if e.row > 0 {
+				e.row--
+			}
+		case tea.KeyDown:
+			if e.row < len(e.lines)-1 {
+				e.row++
+			}
+		case tea.KeyHome, tea.KeyCtrlA:
+			e.col = 0
+		case tea.KeyEnd, tea.KeyCtrlE:
+			e.col = len(line)
+		case tea.KeyRunes, tea.KeySpace, tea.KeyTab:
+			runes := msg.Runes
+			switch msg.Type {
+			case tea.KeySpace:
+				runes = []rune{' '}
+			case tea.KeyTab:
+				runes = []rune{'\t'}
+			}
+			inserted := append(append(append([]rune(nil), line[:e.col]...), runes...), line[e.col:]...)
+			e.lines[e.row] = inserted
+			e.col += len(runes)
+		}
+		if e.col > len(e.lines[e.row]) {
+			e.col = len(e.lines[e.row])
+		}
+		e.scroll()
+	case EditorEvent:
+		if msg.payload == e.Payload {
+			e = e.SetValue(msg.value)
+		}
+	}
+	return e, nil
+}
+
+func (e Editor) View() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s\n\n", e.Message)
+	for i := e.top; i < len(e.lines) && i < e.top+e.height; i++ {
+		line := e.lines[i]
+		if i != e.row {
+			fmt.Fprintf(&buf, "%s\n", string(line))
+			continue
+		}
+		cursor := " "
+		if e.col < len(line) {
+			cursor = string(line[e.col])
+		}
+		rest := ""
+		if e.col < len(line) {
+			rest = string(line[e.col+1:])
+		}
+		fmt.Fprintf(&buf, "%s%s%s\n", string(line[:e.col]), termenv.String(cursor).Reverse(), rest)
+	}
+	for i := len(e.lines) - e.top; i < e.height; i++ {
+		buf.WriteString("~\n")
+	}
+
+	budget := fmt.Sprintf("%d bytes left", e.remaining())
+	if e.remaining() < 0 {
+		budget = text.Color(fmt.Sprintf("%d bytes too long", -e.remaining()), text.Ch1)
+	}
+	fmt.Fprintf(&buf, "\n%s :: CTRL+D to send, CTRL+O to open in %s, Escape to cancel", budget, e.command)
+	return buf.String()
+}
+
+// EditorEvent is sent when the external editor has been closed
+type EditorEvent struct {
+	tab     tabID
+	payload string
+	value   string
+	err     error
+}
+
+func (ee EditorEvent) Tab() tabID {
+	return ee.tab
+}
+
+// openExternal edits the text in the external editor
+func (e Editor) openExternal() tea.Cmd {
+	payload := e.Payload
+	return editExternally(e.command, e.Value(), func(value string, err error) tea.Msg {
+		return EditorEvent{tab: e.tab, payload: payload, value: strings.TrimSuffix(value, "\n"), err: err}
+	})
+}
+
+// editExternally edits value in the external editor command, which is given the terminal until it exits
+// done turns the edited value, or the error, into the message that is sent when the editor has been closed.
+func editExternally(command, value string, done func(string, error) tea.Msg) tea.Cmd {
+	fail := func(err error) tea.Cmd {
+		return func() tea.Msg {
+			return done("", err)
+		}
+	}
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fail(fmt.Errorf("no editor configured"))
+	}
+	f, err := ioutil.TempFile("", "gembro-*.txt")
+	if err != nil {
+		return fail(fmt.Errorf("could not create file to edit: %w", err))
+	}
+	name := f.Name()
+	_, err = f.WriteString(value)
+	f.Close()
+	if err != nil {
+		os.Remove(name)
+		return fail(fmt.Errorf("could not write file to edit: %w", err))
+	}
+	return tea.ExecProcess(exec.Command(args[0], append(args[1:], name)...), func(err error) tea.Msg {
+		defer os.Remove(name)
+		if err != nil {
+			return done("", fmt.Errorf("could not run editor: %w", err))
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return done("", fmt.Errorf("could not read edited file: %w", err))
+		}
+		return done(string(data), nil)
+	})
+}
@@ -16,6 +16,7 @@ type OpenNewTabEvent struct {
 
 type MessageEvent struct {
 	Response bool
+	Choice   string
 	Type     int
 	Payload  string
 }
@@ -38,6 +39,18 @@ type ShowInputEvent struct {
 	Payload        string
 }
 
+type ShowEditorEvent struct {
+	Message, Value string
+	Type           int
+	Payload        string
+}
+
+type ShowOutlineEvent struct{}
+type OutlineEvent struct {
+	Selected bool
+	YPos     int
+}
+
 func fireEvent(msg tea.Msg) func() tea.Msg {
 	return func() tea.Msg {
 		return msg
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"git.sr.ht/~rafael/gembro/gopher"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// loadFile reads the local file or directory of a file URL
+// Gemtext and gophermaps are rendered like remote pages, directories are shown as gemtext indexes.
+func (tab Tab) loadFile(ctx context.Context, u *neturl.URL, scrollPos int, addHist bool) tea.Msg {
+	name := filepath.FromSlash(u.Path)
+	f, err := os.Open(name)
+	if err != nil {
+		return LoadError{err: err, message: "could not open file", tab: tab.id, URL: u.String()}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return LoadError{err: err, message: "could not open file", tab: tab.id, URL: u.String()}
+	}
+	if info.IsDir() {
+		f.Close()
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/" // Relative links of the index resolve inside the directory
+		}
+		index, err := directoryIndex(name, u.Path)
+		if err != nil {
+			return LoadError{err: err, message: "could not read directory", tab: tab.id, URL: u.String()}
+		}
+		if addHist {
+			tab.history.Add(u.String())
+		}
+		return TextResponse{URL: u.String(), Data: []byte(index), MediaType: "text/gemini", scrollPos: scrollPos,
+			tab: tab.id}
+	}
+
+	mediaType := getMediaType(path.Ext(u.Path))
+	if !isGophermap(u.Path) && !isText(mediaType) {
+		return tab.startDownload(f, u.String(), mediaType)
+	}
+	data, err := readPage(ctx, f, tab.opts.MaxPageSize)
+	if err != nil {
+		return LoadError{err: err, message: "could not read file", tab: tab.id, URL: u.String()}
+	}
+	if addHist {
+		tab.history.Add(u.String())
+	}
+	if isGophermap(u.Path) {
+		return GopherResponse{Response: &gopher.Response{Data: gophermapToMenu(data), Type: '1', URL: u.String()},
+			tab: tab.id}
+	}
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	return TextResponse{URL: u.String(), Data: data, MediaType: mediaType, scrollPos: scrollPos, tab: tab.id}
+}
+
+func isGophermap(name string) bool {
+	name = path.Base(name)
+	return name == "gophermap" || strings.HasSuffix(name, ".gophermap")
+}
+
+// gophermapToMenu turns the lines without tabs of a gophermap into info lines of a Gopher menu
+func gophermapToMenu(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if line := strings.TrimRight(line, "\r"); line != "" && !strings.Contains(line, "\t") {
+			lines[i] = "i" + line
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// directoryIndex returns a gemtext page listing the entries of the directory dir at urlPath
+// The links are relative, so urlPath must end with a slash.
+func directoryIndex(dir, urlPath string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "# Index of %s\n\n", urlPath)
+	if urlPath != "/" {
+		fmt.Fprintln(&buf, "=> ../ ..")
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		link := neturl.URL{Path: entry.Name()}
+		if entry.IsDir() {
+			link.Path += "/"
+			fmt.Fprintf(&buf, "=> %s %s/\n", link.String(), entry.Name())
+			continue
+		}
+		fmt.Fprintf(&buf, "=> %s %s (%s)\n", link.String(), entry.Name(), formatSize(entry.Size()))
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,61 @@
+package finger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	neturl "net/url"
+	"strings"
+)
+
+type Response struct {
+	Data []byte
+	URL  string
+	// Stream is only set by StreamURL
+	Stream io.ReadCloser
+}
+
+func LoadURL(ctx context.Context, url neturl.URL) (*Response, error) {
+	resp, err := StreamURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Stream.Close()
+	data, err := io.ReadAll(resp.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("error in finger response: %w", err)
+	}
+	resp.Data = data
+	resp.Stream = nil
+	return resp, nil
+}
+
+// Query returns the query to send for url, finger://host/user and finger://user@host are both supported
+func Query(url neturl.URL) string {
+	if url.User != nil {
+		return url.User.Username()
+	}
+	return strings.TrimPrefix(url.Path, "/")
+}
+
+// StreamURL sends the query for url and returns a response with the unread reply in Stream,
+// which the caller must close. ctx is only used for connecting.
+func StreamURL(ctx context.Context, url neturl.URL) (*Response, error) {
+	log.Printf("finger load: %s", url.String())
+	host := url.Host
+	if url.Port() == "" {
+		host = net.JoinHostPort(url.Hostname(), "79")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial finger %q: %w", host, err)
+	}
+	if _, err := fmt.Fprintf(conn, "%s\r\n", Query(url)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not send finger query: %w", err)
+	}
+	return &Response{URL: url.String(), Stream: conn}, nil
+}
@@ -1,27 +1,139 @@
 package gemini
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
 )
 
-func GenerateClientCertificate(certFile, keyFile string, config x509.Certificate) error {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+type KeyType string
+
+const (
+	KeyRSA     KeyType = "rsa"
+	KeyECDSA   KeyType = "ecdsa"
+	KeyEd25519 KeyType = "ed25519"
+)
+
+// ParseKeyType parses the name of a key type as used in KeyType
+func ParseKeyType(s string) (KeyType, error) {
+	switch kt := KeyType(s); kt {
+	case KeyRSA, KeyECDSA, KeyEd25519:
+		return kt, nil
+	}
+	return "", fmt.Errorf("unknown key type %q (must be %s, %s or %s)", s, KeyRSA, KeyECDSA, KeyEd25519)
+}
+
+// KeyTypeOf returns the key type of the public key of cert
+func KeyTypeOf(cert *x509.Certificate) KeyType {
+	switch cert.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return KeyECDSA
+	case x509.Ed25519:
+		return KeyEd25519
+	default:
+		return KeyRSA
+	}
+}
+
+// CertificateOptions describe a client certificate to generate
+type CertificateOptions struct {
+	KeyType      KeyType
+	Validity     time.Duration
+	CommonName   string
+	Organization string
+	Email        string
+}
+
+// NewCertificateTemplate returns a template for a certificate that is valid from now on
+// It has a random serial number, so each call returns a unique template.
+func NewCertificateTemplate(opts CertificateOptions) (x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return x509.Certificate{}, fmt.Errorf("could not generate serial number: %w", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		NotBefore:    now,
+		NotAfter:     now.Add(opts.Validity),
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: opts.CommonName,
+		},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if opts.Organization != "" {
+		template.Subject.Organization = []string{opts.Organization}
+	}
+	if opts.Email != "" {
+		template.EmailAddresses = []string{opts.Email}
+	}
+	return template, nil
+}
+
+func generateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case KeyRSA, "":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+}
+
+// NewServerCertificateTemplate returns a template for a certificate of a server for hosts
+// Hosts can be names or IP addresses.
+func NewServerCertificateTemplate(opts CertificateOptions, hosts []string) (x509.Certificate, error) {
+	template, err := NewCertificateTemplate(opts)
+	if err != nil {
+		return template, err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return template, nil
+}
+
+// GenerateCertificate writes a self-signed certificate based on config and its private key
+// The key is stored in PKCS #8 format.
+func GenerateCertificate(certFile, keyFile string, keyType KeyType, config x509.Certificate) error {
+	key, err := generateKey(keyType)
 	if err != nil {
 		return fmt.Errorf("Private key cannot be created: %w", err)
 	}
 
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("Private key cannot be encoded: %w", err)
+	}
 	// Generate a pem block with the private key
 	keyPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+		Type:  "PRIVATE KEY",
+		Bytes: der,
 	})
 
-	cert, err := x509.CreateCertificate(rand.Reader, &config, &config, &key.PublicKey, key)
+	cert, err := x509.CreateCertificate(rand.Reader, &config, &config, key.Public(), key)
 	if err != nil {
 		return fmt.Errorf("Certificate cannot be created: %w", err)
 	}
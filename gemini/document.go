@@ -0,0 +1,114 @@
+package gemini
+
+import (
+	"strings"
+	"unicode"
+)
+
+// LineType is the type of a line of a gemtext document
+type LineType int
+
+const (
+	LineText LineType = iota
+	LineLink
+	LineHeading1
+	LineHeading2
+	LineHeading3
+	LineListItem
+	LineQuote
+	LinePreformatted
+)
+
+// HeadingLevel returns 1 to 3 for heading lines and 0 for other lines
+func (t LineType) HeadingLevel() int {
+	switch t {
+	case LineHeading1:
+		return 1
+	case LineHeading2:
+		return 2
+	case LineHeading3:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Line is a line of a gemtext document, a preformatted block with all its lines is a single Line
+type Line struct {
+	Type LineType
+	// Text is the content of the line without its prefix, or the name of a link
+	// An invalid link keeps the whole line.
+	Text string
+	// URL of a link as written in the document, it is empty for an invalid link
+	URL string
+	// Alt is the alt text of a preformatted block
+	Alt string
+	// Lines of a preformatted block
+	Lines []string
+}
+
+// Document is gemtext parsed into lines
+type Document struct {
+	Lines []Line
+}
+
+// Parse parses gemtext into a document
+// A preformatted block that is not closed runs until the end of the document.
+func Parse(data string) Document {
+	var doc Document
+	var pre *Line
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if pre != nil {
+			if strings.HasPrefix(line, "```") {
+				doc.Lines = append(doc.Lines, *pre)
+				pre = nil
+				continue
+			}
+			pre.Lines = append(pre.Lines, line)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "```"):
+			pre = &Line{Type: LinePreformatted, Alt: strings.TrimSpace(line[3:])}
+		case strings.HasPrefix(line, "=>"):
+			l, err := ParseLink(line)
+			if err != nil {
+				doc.Lines = append(doc.Lines, Line{Type: LineLink, Text: line})
+				continue
+			}
+			doc.Lines = append(doc.Lines, Line{Type: LineLink, Text: l.Name, URL: l.URL})
+		case strings.HasPrefix(line, "###"):
+			doc.Lines = append(doc.Lines, Line{Type: LineHeading3, Text: trimPrefix(line, "###")})
+		case strings.HasPrefix(line, "##"):
+			doc.Lines = append(doc.Lines, Line{Type: LineHeading2, Text: trimPrefix(line, "##")})
+		case strings.HasPrefix(line, "#"):
+			doc.Lines = append(doc.Lines, Line{Type: LineHeading1, Text: trimPrefix(line, "#")})
+		case strings.HasPrefix(line, "* "):
+			doc.Lines = append(doc.Lines, Line{Type: LineListItem, Text: line[2:]})
+		case strings.HasPrefix(line, ">"):
+			doc.Lines = append(doc.Lines, Line{Type: LineQuote, Text: trimPrefix(line, ">")})
+		default:
+			doc.Lines = append(doc.Lines, Line{Type: LineText, Text: line})
+		}
+	}
+	if pre != nil {
+		doc.Lines = append(doc.Lines, *pre)
+	}
+	return doc
+}
+
+// trimPrefix removes prefix and the whitespace following it from line
+func trimPrefix(line, prefix string) string {
+	return strings.TrimLeftFunc(strings.TrimPrefix(line, prefix), unicode.IsSpace)
+}
+
+// Title returns the text of the first level 1 heading, or "" if there is none
+func (doc Document) Title() string {
+	for _, line := range doc.Lines {
+		if line.Type == LineHeading1 {
+			return line.Text
+		}
+	}
+	return ""
+}
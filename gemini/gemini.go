@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/url"
 	"strings"
 	"unicode"
@@ -50,19 +51,34 @@ type Response struct {
 	Header Header
 	URL    string
 	Body   []byte
+	// Stream is only set by StreamURL for successful responses
+	Stream io.ReadCloser
+	// Identity is the name of the identity that was sent with the request, if any
+	Identity string
+	// CertRenewed is set when the server certificate changed after the pinned one expired
+	CertRenewed bool
+	// Verification is how the server certificate was verified
+	Verification Verification
 }
 
 type Client struct {
 	certStore  *CertStore
-	clientCert *tls.Certificate
+	identities *Identities
+	policy     VerifyPolicy
 }
 
-func NewClient(certsPath string, clientCert *tls.Certificate) (*Client, error) {
+// NewClient returns a client that pins server certificates in certsPath
+// identities can be nil when no client certificates should be sent
+func NewClient(certsPath string, identities *Identities, policy VerifyPolicy) (*Client, error) {
 	cs, err := Load(certsPath)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{certStore: cs, clientCert: clientCert}, nil
+	return &Client{certStore: cs, identities: identities, policy: policy}, nil
+}
+
+func (client *Client) Identities() *Identities {
+	return client.identities
 }
 
 func (r *Response) GetBody() (string, error) {
@@ -77,28 +93,83 @@ func (r *Response) GetBody() (string, error) {
 	return string(body), nil
 }
 
-func (client *Client) LoadURL(ctx context.Context, surl url.URL, skipVerify bool) (*Response, error) {
+func (client *Client) LoadURL(ctx context.Context, surl url.URL, trust Trust) (*Response, error) {
+	resp, err := client.StreamURL(ctx, surl, trust)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Stream == nil {
+		return resp, nil
+	}
+	defer resp.Stream.Close()
+	data, err := io.ReadAll(resp.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("could not ready body: %w", err)
+	}
+	resp.Body = data
+	resp.Stream = nil
+	return resp, nil
+}
+
+// StreamURL works like LoadURL but does not wait for the body of a successful response.
+// Instead the body can be read from Response.Stream as it arrives, which the caller must close.
+func (client *Client) StreamURL(ctx context.Context, surl url.URL, trust Trust) (*Response, error) {
 	if surl.Path == "" {
 		surl.Path = "/"
 	}
+	return client.request(ctx, surl, surl.String(), nil, trust)
+}
+
+// connection is a connection to a server with what is known about it
+type connection struct {
+	conn         net.Conn
+	identity     string
+	renewed      bool
+	verification Verification
+}
+
+// dial connects to the server of surl, sending the client certificate of the identity for surl if any
+func (client *Client) dial(ctx context.Context, surl url.URL, trust Trust) (*connection, error) {
 	port := surl.Port()
 	if port == "" {
 		port = "1965"
 	}
+	c := &connection{}
 	var certs []tls.Certificate
-	if client.clientCert != nil {
-		certs = append(certs, *client.clientCert)
+	if client.identities != nil {
+		// Identities are bound to gemini URLs, titan URLs of the same capsule use the same identity
+		idURL := surl
+		idURL.Scheme = "gemini"
+		if id := client.identities.For(idURL); id != nil {
+			certs = append(certs, *id.cert)
+			c.identity = id.Name
+		}
 	}
+	mode := client.policy.For(surl.Hostname())
 	d := tls.Dialer{
 		Config: &tls.Config{
 			InsecureSkipVerify: true,
 			VerifyConnection: func(state tls.ConnectionState) error {
+				if mode != VerifyTOFU {
+					err := verifyChain(surl.Hostname(), state.PeerCertificates)
+					if err == nil {
+						c.verification = VerifiedCA
+						return nil
+					}
+					if mode == VerifyCA {
+						return fmt.Errorf("certificate is not verified by a certificate authority: %w", err)
+					}
+				}
 				fixCert(state.PeerCertificates[0])
 				err := state.PeerCertificates[0].VerifyHostname(surl.Hostname())
 				if err != nil {
 					return err
 				}
-				return client.certStore.Check(surl.Hostname(), state.PeerCertificates[0], skipVerify)
+				c.renewed, err = client.certStore.Check(surl.Hostname(), port, state.PeerCertificates[0], trust)
+				if err == nil {
+					c.verification = VerifiedTOFU
+				}
+				return err
 			},
 			Certificates: certs,
 		},
@@ -107,33 +178,65 @@ func (client *Client) LoadURL(ctx context.Context, surl url.URL, skipVerify bool
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to server: %w", err)
 	}
-	defer conn.Close()
+	c.conn = conn
+	return c, nil
+}
+
+// request sends the request line and body, if any, to the server of surl and reads the header of the response
+func (client *Client) request(ctx context.Context, surl url.URL, line string, body io.Reader,
+	trust Trust) (*Response, error) {
+	c, err := client.dial(ctx, surl, trust)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.conn
+
+	// Sending the request and reading the header can be canceled by ctx,
+	// reading the body only by closing the stream
+	headerRead := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-headerRead:
+		}
+	}()
 
-	// Send URL
-	if _, err := fmt.Fprintf(conn, "%s\r\n", surl.String()); err != nil {
+	if _, err := fmt.Fprintf(conn, "%s\r\n", line); err != nil {
+		close(headerRead)
+		conn.Close()
 		return nil, fmt.Errorf("could not send url: %w", err)
 	}
+	if body != nil {
+		if _, err := io.Copy(conn, body); err != nil {
+			close(headerRead)
+			conn.Close()
+			return nil, fmt.Errorf("could not send data: %w", err)
+		}
+	}
 
-	rdr := bufio.NewReader(io.LimitReader(conn, 1024*1024))
+	rdr := bufio.NewReader(conn)
 	header, err := readHeader(rdr)
+	close(headerRead)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	resp := &Response{Header: *header, URL: surl.String()}
-	switch header.Status {
-	case 1: // input
-		return resp, nil
-	case 2: // success
-		data, err := io.ReadAll(rdr)
-		if err != nil {
-			return nil, fmt.Errorf("could not ready body: %w", err)
-		}
-		resp.Body = data
-		return resp, nil
-	default:
+	resp := &Response{Header: *header, URL: surl.String(), Identity: c.identity, CertRenewed: c.renewed,
+		Verification: c.verification}
+	if header.Status != 2 {
+		conn.Close()
 		return resp, nil
 	}
+	resp.Stream = stream{rdr, conn}
+	return resp, nil
+}
+
+// stream is the body of a response that is still being received
+type stream struct {
+	*bufio.Reader
+	io.Closer
 }
 
 type Link struct {
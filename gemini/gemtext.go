@@ -16,61 +16,138 @@ const TextWidth = 80
 func ToANSI(data string, availableWidth int, baseURL neturl.URL) (
 	content string, links text.Links, title string) {
 
+	doc := Parse(data)
+	content, links, _ = doc.ToANSI(availableWidth, baseURL, RenderOptions{})
+	title = doc.Title()
+	if title == "" {
+		title = baseURL.String()
+	}
+	return content, links, title
+}
+
+// RenderOptions change how a document is rendered
+type RenderOptions struct {
+	// Collapse shows preformatted blocks as a single caption line
+	Collapse bool
+	// PreOffset is the number of columns preformatted lines are scrolled to the left
+	PreOffset int
+}
+
+// Heading is a heading of a rendered document
+type Heading struct {
+	Level int
+	Text  string
+	// YPos is the line of the heading in the rendered text
+	YPos int
+}
+
+// ToANSI renders the document as text suitable for terminal output with colors
+// It returns the rendered text, a list of links and the headings with their vertical positions.
+func (doc Document) ToANSI(availableWidth int, baseURL neturl.URL, opts RenderOptions) (
+	content string, links text.Links, headings []Heading) {
+
 	var s strings.Builder
-	var mono bool
 	ypos := 0
-	for _, line := range strings.Split(data, "\n") {
-		if strings.HasPrefix(line, "```") {
-			mono = !mono
-			continue
-		}
-		if !mono && strings.HasPrefix(line, "# ") {
-			fmt.Fprintln(&s, text.Color(line[2:], text.Ch1))
+	// Preformatted lines are not wrapped but cut at the right edge of the screen
+	preWidth := availableWidth - text.Margin(availableWidth, TextWidth)
+	for _, line := range doc.Lines {
+		switch line.Type {
+		case LineHeading1, LineHeading2, LineHeading3:
+			h := Heading{Level: line.Type.HeadingLevel(), Text: line.Text, YPos: ypos}
+			headings = append(headings, h)
+			fmt.Fprintln(&s, text.Color(line.Text, HeadingColor(h.Level)))
 			ypos++
-			if title == "" {
-				title = line[2:]
+		case LineLink:
+			name := line.Text
+			if line.URL == "" {
+				name = "Invalid link: " + line.Text
 			}
-			continue
-		}
-		if !mono && strings.HasPrefix(line, "## ") {
-			fmt.Fprintln(&s, text.Color(line[3:], text.Ch2))
-			ypos++
-			continue
-		}
-		if !mono && strings.HasPrefix(line, "### ") {
-			fmt.Fprintln(&s, text.Color(line[4:], text.Ch3))
-			ypos++
-			continue
-		}
-		if !mono && strings.HasPrefix(line, "=>") {
-			l, err := ParseLink(line)
+			furl, err := baseURL.Parse(line.URL)
 			if err != nil {
-				l = &Link{URL: "", Name: "Invalid link: " + line}
+				furl = &neturl.URL{}
 			}
-
-			furl, _ := baseURL.Parse(l.URL)
 			var extra string
-			if furl.Scheme != "gemini" {
+			if furl.Scheme != "gemini" && furl.Scheme != baseURL.Scheme {
 				extra = fmt.Sprintf(" (%s)", furl.Scheme)
 			}
 			count := links.Count() + 1
-			links.Add(ypos, count, furl.String(), l.Name)
-			fmt.Fprintf(&s, "%d> %s %s\n", count, text.Color(l.Name, text.Clink), extra)
-			ypos++
-			continue
-		}
-		if mono {
-			fmt.Fprintln(&s, text.Color(line, text.Ccode))
+			links.Add(ypos, count, furl.String(), name)
+			fmt.Fprintf(&s, "%d> %s %s\n", count, text.Color(name, text.Clink), extra)
 			ypos++
-			continue
+		case LinePreformatted:
+			if opts.Collapse {
+				caption := line.Alt
+				if caption == "" {
+					caption = "Preformatted text"
+				}
+				count := fmt.Sprintf("%d lines", len(line.Lines))
+				if len(line.Lines) == 1 {
+					count = "1 line"
+				}
+				fmt.Fprintln(&s, text.Color(fmt.Sprintf("▸ %s (%s)", caption, count), text.Ccaption))
+				ypos++
+				continue
+			}
+			if line.Alt != "" {
+				fmt.Fprintln(&s, text.Color("▾ "+line.Alt, text.Ccaption))
+				ypos++
+			}
+			highlighted, ok := text.Highlight(line.Lines, line.Alt)
+			for i, l := range line.Lines {
+				spans := []text.Span{{Text: l, Color: text.Ccode}}
+				if ok {
+					spans = highlighted[i]
+				}
+				for _, span := range text.CutSpans(spans, opts.PreOffset, preWidth) {
+					s.WriteString(text.Color(span.Text, span.Color))
+				}
+				fmt.Fprintln(&s)
+				ypos++
+			}
+		case LineListItem:
+			w := prefixLines(text.Wrap(line.Text, TextWidth-2), text.Color("•", text.Clist)+" ", "  ")
+			fmt.Fprint(&s, w)
+			ypos += strings.Count(w, "\n")
+		case LineQuote:
+			bar := text.Color("│", text.Cquote) + " "
+			w := prefixLines(text.Wrap(line.Text, TextWidth-2), bar, bar)
+			fmt.Fprint(&s, w)
+			ypos += strings.Count(w, "\n")
+		default:
+			w := text.Wrap(line.Text, TextWidth)
+			fmt.Fprint(&s, w)
+			ypos += strings.Count(w, "\n")
 		}
+	}
+	return text.ApplyMargin(s.String(), availableWidth, TextWidth), links, headings
+}
 
-		w := text.Wrap(line, TextWidth)
-		fmt.Fprint(&s, w)
-		ypos += strings.Count(w, "\n")
+// HeadingColor returns the color of headings of level 1 to 3
+func HeadingColor(level int) text.Colo {
+	switch level {
+	case 1:
+		return text.Ch1
+	case 2:
+		return text.Ch2
+	default:
+		return text.Ch3
 	}
-	if title == "" {
-		title = baseURL.String()
+}
+
+// prefixLines puts first before the first line of wrapped text and rest before the following lines
+func prefixLines(wrapped, first, rest string) string {
+	lines := strings.SplitAfter(wrapped, "\n")
+	var s strings.Builder
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		if i == 0 {
+			s.WriteString(first)
+		} else {
+			s.WriteString(rest)
+		}
+		s.WriteString(line)
 	}
-	return text.ApplyMargin(s.String(), availableWidth, TextWidth), links, title
+	return s.String()
 }
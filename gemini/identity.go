@@ -0,0 +1,375 @@
+package gemini
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const identitiesName = "identities.json"
+
+// Identity is a named client certificate, it is only sent to URLs starting with one of its prefixes
+type Identity struct {
+	Name     string
+	Prefixes []string
+	cert     *tls.Certificate
+}
+
+// Identities stores client certificates in a directory
+type Identities struct {
+	lock       sync.Mutex
+	Identities []*Identity
+	dir        string
+}
+
+// LoadIdentities loads the identities stored in dir, the dir is created when it does not exist yet
+func LoadIdentities(dir string) (*Identities, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create identities dir: %w", err)
+	}
+	ids := &Identities{dir: dir}
+	f, err := os.Open(filepath.Join(dir, identitiesName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ids, nil
+		}
+		return nil, fmt.Errorf("could not open identities file: %w", err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(ids); err != nil {
+		return nil, fmt.Errorf("could not decode identities: %w", err)
+	}
+	for _, id := range ids.Identities {
+		if err := ids.loadCert(id); err != nil {
+			log.Print(err)
+		}
+	}
+	return ids, nil
+}
+
+func (ids *Identities) loadCert(id *Identity) error {
+	cert, err := tls.LoadX509KeyPair(ids.CertFile(id.Name), ids.KeyFile(id.Name))
+	if err != nil {
+		return fmt.Errorf("could not load certificate of identity %q: %w", id.Name, err)
+	}
+	id.cert = &cert
+	return nil
+}
+
+// CertFile returns the path of the certificate of the identity with the given name
+func (ids *Identities) CertFile(name string) string {
+	return filepath.Join(ids.dir, name+".crt")
+}
+
+// KeyFile returns the path of the private key of the identity with the given name
+func (ids *Identities) KeyFile(name string) string {
+	return filepath.Join(ids.dir, name+".key")
+}
+
+func validName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\:`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid identity name %q", name)
+	}
+	return nil
+}
+
+// Create generates a new identity with a certificate described by opts
+func (ids *Identities) Create(name string, opts CertificateOptions) (*Identity, error) {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	if ids.get(name) != nil {
+		return nil, fmt.Errorf("identity %q already exists", name)
+	}
+	template, err := NewCertificateTemplate(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := GenerateCertificate(ids.CertFile(name), ids.KeyFile(name), opts.KeyType, template); err != nil {
+		return nil, err
+	}
+	id := &Identity{Name: name}
+	if err := ids.loadCert(id); err != nil {
+		return nil, err
+	}
+	ids.Identities = append(ids.Identities, id)
+	return id, ids.save()
+}
+
+// Regenerate replaces the certificate of an identity with a new one that is valid for the given duration
+// The new certificate has the same subject and key type, the name and bindings of the identity stay the same.
+func (ids *Identities) Regenerate(name string, validity time.Duration) error {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	id := ids.get(name)
+	if id == nil {
+		return fmt.Errorf("identity %q does not exist", name)
+	}
+	opts := CertificateOptions{KeyType: KeyRSA, Validity: validity, CommonName: name}
+	if old := id.Certificate(); old != nil {
+		opts.KeyType = KeyTypeOf(old)
+		opts.CommonName = old.Subject.CommonName
+		if len(old.Subject.Organization) > 0 {
+			opts.Organization = old.Subject.Organization[0]
+		}
+		if len(old.EmailAddresses) > 0 {
+			opts.Email = old.EmailAddresses[0]
+		}
+	}
+	template, err := NewCertificateTemplate(opts)
+	if err != nil {
+		return err
+	}
+	certFile, keyFile := ids.CertFile(name)+".new", ids.KeyFile(name)+".new"
+	if err := GenerateCertificate(certFile, keyFile, opts.KeyType, template); err != nil {
+		return err
+	}
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+	// The old key is kept until the certificate is replaced as well, so a failure leaves a matching pair
+	oldKeyFile := ids.KeyFile(name) + ".old"
+	if err := os.Rename(ids.KeyFile(name), oldKeyFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not replace key: %w", err)
+	}
+	if err := os.Rename(keyFile, ids.KeyFile(name)); err != nil {
+		os.Rename(oldKeyFile, ids.KeyFile(name))
+		return fmt.Errorf("could not replace key: %w", err)
+	}
+	if err := os.Rename(certFile, ids.CertFile(name)); err != nil {
+		os.Rename(oldKeyFile, ids.KeyFile(name))
+		return fmt.Errorf("could not replace certificate: %w", err)
+	}
+	os.Remove(oldKeyFile)
+	return ids.loadCert(id)
+}
+
+// Import adds an identity for an existing certificate and key pair, the files are moved into the store
+func (ids *Identities) Import(name, certFile, keyFile string) (*Identity, error) {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	if ids.get(name) != nil {
+		return nil, fmt.Errorf("identity %q already exists", name)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return nil, fmt.Errorf("could not load certificate: %w", err)
+	}
+	if err := os.Rename(certFile, ids.CertFile(name)); err != nil {
+		return nil, fmt.Errorf("could not move certificate: %w", err)
+	}
+	if err := os.Rename(keyFile, ids.KeyFile(name)); err != nil {
+		return nil, fmt.Errorf("could not move key: %w", err)
+	}
+	id := &Identity{Name: name}
+	if err := ids.loadCert(id); err != nil {
+		return nil, err
+	}
+	ids.Identities = append(ids.Identities, id)
+	return id, ids.save()
+}
+
+// Get returns the identity with the given name or nil if it does not exist
+func (ids *Identities) Get(name string) *Identity {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	return ids.get(name)
+}
+
+func (ids *Identities) get(name string) *Identity {
+	for _, id := range ids.Identities {
+		if id.Name == name {
+			return id
+		}
+	}
+	return nil
+}
+
+// Names returns the names of all identities in alphabetical order
+func (ids *Identities) Names() []string {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	var names []string
+	for _, id := range ids.Identities {
+		names = append(names, id.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// All returns copies of all identities in alphabetical order
+func (ids *Identities) All() []Identity {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	var all []Identity
+	for _, id := range ids.Identities {
+		c := *id
+		c.Prefixes = append([]string(nil), id.Prefixes...)
+		all = append(all, c)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Certificate returns the parsed certificate of the identity, or nil when it could not be loaded
+func (id Identity) Certificate() *x509.Certificate {
+	if id.cert == nil || len(id.cert.Certificate) == 0 {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(id.cert.Certificate[0])
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	return cert
+}
+
+// Rename gives an identity a new name, its bindings stay the same
+func (ids *Identities) Rename(name, newName string) error {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	if err := validName(newName); err != nil {
+		return err
+	}
+	id := ids.get(name)
+	if id == nil {
+		return fmt.Errorf("identity %q does not exist", name)
+	}
+	if ids.get(newName) != nil {
+		return fmt.Errorf("identity %q already exists", newName)
+	}
+	if err := os.Rename(ids.CertFile(name), ids.CertFile(newName)); err != nil {
+		return fmt.Errorf("could not rename certificate: %w", err)
+	}
+	if err := os.Rename(ids.KeyFile(name), ids.KeyFile(newName)); err != nil {
+		return fmt.Errorf("could not rename key: %w", err)
+	}
+	id.Name = newName
+	return ids.save()
+}
+
+// Delete removes an identity together with its certificate and key
+func (ids *Identities) Delete(name string) error {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	var rest []*Identity
+	for _, id := range ids.Identities {
+		if id.Name != name {
+			rest = append(rest, id)
+		}
+	}
+	ids.Identities = rest
+	for _, path := range []string{ids.CertFile(name), ids.KeyFile(name)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove identity file: %w", err)
+		}
+	}
+	return ids.save()
+}
+
+// Export writes the certificate followed by the private key of an identity to a single PEM file
+func (ids *Identities) Export(name, path string) error {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	if ids.get(name) == nil {
+		return fmt.Errorf("identity %q does not exist", name)
+	}
+	var data []byte
+	for _, file := range []string{ids.CertFile(name), ids.KeyFile(name)} {
+		d, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("could not read identity file: %w", err)
+		}
+		data = append(data, d...)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("could not export identity: %w", err)
+	}
+	return nil
+}
+
+// Bind makes the identity with the given name the one to use for all URLs starting with prefix
+func (ids *Identities) Bind(name, prefix string) error {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	id := ids.get(name)
+	if id == nil {
+		return fmt.Errorf("identity %q does not exist", name)
+	}
+	ids.unbind(prefix)
+	id.Prefixes = append(id.Prefixes, prefix)
+	return ids.save()
+}
+
+// Unbind stops using any identity for URLs starting with prefix
+func (ids *Identities) Unbind(prefix string) error {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	ids.unbind(prefix)
+	return ids.save()
+}
+
+func (ids *Identities) unbind(prefix string) {
+	for _, id := range ids.Identities {
+		var prefixes []string
+		for _, p := range id.Prefixes {
+			if p != prefix {
+				prefixes = append(prefixes, p)
+			}
+		}
+		id.Prefixes = prefixes
+	}
+}
+
+// For returns the identity bound to the longest prefix of u, or nil when there is none
+func (ids *Identities) For(u url.URL) *Identity {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	u.RawQuery = ""
+	u.Fragment = ""
+	s := u.String()
+	var found *Identity
+	var length int
+	for _, id := range ids.Identities {
+		for _, p := range id.Prefixes {
+			if strings.HasPrefix(s, p) && len(p) > length && id.cert != nil {
+				found = id
+				length = len(p)
+			}
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	c := *found
+	return &c
+}
+
+func (ids *Identities) save() error {
+	data, err := json.MarshalIndent(ids, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode identities: %w", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ids.dir, identitiesName), data, 0600); err != nil {
+		return fmt.Errorf("could not save identities: %w", err)
+	}
+	return nil
+}
+
+// HostPrefix returns the prefix that matches all URLs on the host of u
+func HostPrefix(u url.URL) string {
+	return fmt.Sprintf("%s://%s/", u.Scheme, u.Host)
+}
@@ -0,0 +1,112 @@
+package gemini
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseKnownHosts reads the pinned certificates of another client
+// Both the tofu.toml of Amfora and the trusted.2.txt of Lagrange are understood.
+func ParseKnownHosts(in io.Reader) (map[string]Pin, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known hosts: %w", err)
+	}
+	if strings.Contains(string(data), "=") {
+		return parseAmfora(string(data))
+	}
+	return parseLagrange(string(data))
+}
+
+// amforaExpiry is the suffix of the keys of expiry dates in the tofu.toml of Amfora
+const amforaExpiry = "/expiry"
+
+// parseAmfora parses TOML keys of a host with its dots replaced by slashes and the port added unless it is 1965
+// A key maps to the SHA-256 fingerprint of the public key, the key with "/expiry" added to the expiry date.
+func parseAmfora(data string) (map[string]Pin, error) {
+	pins := make(map[string]Pin)
+	expiry := make(map[string]time.Time)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.Trim(strings.TrimSpace(parts[0]), `"'`)
+		value := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		if strings.HasSuffix(key, amforaExpiry) {
+			t, err := parseTOMLTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid expiry date: %w", n, err)
+			}
+			expiry[amforaHost(strings.TrimSuffix(key, amforaExpiry))] = t
+			continue
+		}
+		if _, err := hex.DecodeString(value); err != nil || len(value) != sha256.Size*2 {
+			return nil, fmt.Errorf("line %d: invalid fingerprint %q", n, value)
+		}
+		pins[amforaHost(key)] = Pin{KeyFingerprint: strings.ToLower(value)}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for key, t := range expiry {
+		if p, ok := pins[key]; ok {
+			p.NotAfter = t
+			pins[key] = p
+		}
+	}
+	return pins, nil
+}
+
+// amforaHost returns the host:port of a key of Amfora
+func amforaHost(key string) string {
+	return HostKey(strings.ReplaceAll(key, "/", "."))
+}
+
+// parseTOMLTime parses a TOML date-time, which may separate the date and time by a space
+func parseTOMLTime(value string) (time.Time, error) {
+	value = strings.Replace(value, " ", "T", 1)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05", value) // Local date-time without offset
+}
+
+// parseLagrange parses lines of a host, the expiry as Unix time and the fingerprint of the public key
+// A port other than 1965 follows the host after a semicolon.
+func parseLagrange(data string) (map[string]Pin, error) {
+	pins := make(map[string]Pin)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected host, expiry and fingerprint", n)
+		}
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry: %w", n, err)
+		}
+		pins[HostKey(strings.Replace(fields[0], ";", ":", 1))] = Pin{
+			KeyFingerprint: strings.ToLower(fields[2]),
+			NotAfter:       time.Unix(secs, 0),
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
@@ -0,0 +1,75 @@
+package gemini
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testKeyHash1 = "3a8e6a56c6ae0d16f16bbe5e48e18a3dd5ad4b1d3eb42b3a7dc5f2e8f1d3d9a0"
+	testKeyHash2 = "f01c1b5d0a8e4e2c9a7b6d5c4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f"
+)
+
+func TestParseKnownHosts(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]Pin
+	}{
+		{
+			name: "amfora",
+			data: `"gemini/circumlunar/space" = "` + strings.ToUpper(testKeyHash1) + `"
+"gemini/circumlunar/space/expiry" = 2025-06-03T18:12:31Z
+"localhost:1966" = "` + strings.ToUpper(testKeyHash2) + `"
+"localhost:1966/expiry" = 2024-01-01T00:00:00Z
+`,
+			want: map[string]Pin{
+				"gemini.circumlunar.space:1965": {
+					KeyFingerprint: testKeyHash1,
+					NotAfter:       time.Date(2025, 6, 3, 18, 12, 31, 0, time.UTC),
+				},
+				"localhost:1966": {
+					KeyFingerprint: testKeyHash2,
+					NotAfter:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name: "lagrange",
+			data: "gemini.circumlunar.space 1748974351 " + testKeyHash1 + "\n" +
+				"localhost;1966 1704067200 " + strings.ToUpper(testKeyHash2) + "\n",
+			want: map[string]Pin{
+				"gemini.circumlunar.space:1965": {
+					KeyFingerprint: testKeyHash1,
+					NotAfter:       time.Unix(1748974351, 0),
+				},
+				"localhost:1966": {
+					KeyFingerprint: testKeyHash2,
+					NotAfter:       time.Unix(1704067200, 0),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins, err := ParseKnownHosts(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pins) != len(tt.want) {
+				t.Fatalf("got %d pins, want %d: %v", len(pins), len(tt.want), pins)
+			}
+			for key, want := range tt.want {
+				got, ok := pins[key]
+				if !ok {
+					t.Fatalf("missing pin of %s in %v", key, pins)
+				}
+				if got.Fingerprint != "" || got.KeyFingerprint != want.KeyFingerprint ||
+					!got.NotAfter.Equal(want.NotAfter) {
+					t.Errorf("pin of %s is %+v, want %+v", key, got, want)
+				}
+			}
+		})
+	}
+}
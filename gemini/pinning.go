@@ -1,22 +1,113 @@
 package gemini
 
 import (
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"net"
 	"os"
 	"sync"
+	"time"
 )
 
 var NotFound = errors.New("cert not found")
 var CertChanged = errors.New("cert has changed")
 
+// seenInterval is how often the last seen date of a pin is updated
+const seenInterval = 24 * time.Hour
+
+// Pin is what is known about the trusted certificate of a host
+type Pin struct {
+	Fingerprint string
+	// KeyFingerprint is the SHA-256 fingerprint of the public key, some clients pin that instead
+	KeyFingerprint string `json:",omitempty"`
+	NotBefore      time.Time
+	NotAfter       time.Time
+	FirstSeen      time.Time
+	LastSeen       time.Time
+	Subject        string   `json:",omitempty"`
+	Issuer         string   `json:",omitempty"`
+	Names          []string `json:",omitempty"`
+	KeyType        string   `json:",omitempty"`
+}
+
+// Trust is a decision of the user to trust a certificate that differs from the pinned one
+// The zero value trusts only pinned certificates.
+type Trust struct {
+	// Fingerprint of the certificate to trust
+	Fingerprint string
+	// Permanent replaces the pinned certificate, otherwise it is trusted for a single request
+	Permanent bool
+}
+
+// CertChangedError is returned when the certificate of a host differs from the pinned one
+type CertChangedError struct {
+	Host   string
+	Pinned Pin
+	Cert   *x509.Certificate
+}
+
+func (e *CertChangedError) Error() string {
+	return CertChanged.Error()
+}
+
+func (e *CertChangedError) Is(target error) bool {
+	return target == CertChanged
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert as used in pins
+func Fingerprint(cert *x509.Certificate) string {
+	return fingerprint(cert)
+}
+
+// NewPin returns the pin for cert as it would be stored when first seen now
+func NewPin(cert *x509.Certificate) Pin {
+	now := time.Now()
+	return Pin{
+		Fingerprint:    fingerprint(cert),
+		KeyFingerprint: keyFingerprint(cert),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		FirstSeen:      now,
+		LastSeen:       now,
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		Names:          append(append([]string(nil), cert.DNSNames...), ipStrings(cert)...),
+		KeyType:        keyDescription(cert),
+	}
+}
+
+func ipStrings(cert *x509.Certificate) []string {
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+func keyDescription(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bits", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
 type CertStore struct {
-	lock         sync.Mutex
-	Certificates map[string]string
-	savePath     string
+	lock sync.Mutex
+	// Certificates maps hosts to fingerprints, it is only used to load stores of older versions
+	Certificates map[string]string `json:",omitempty"`
+	// Hosts maps host:port to the pin of the host
+	Hosts    map[string]*Pin
+	savePath string
 }
 
 func fingerprint(cert *x509.Certificate) string {
@@ -25,27 +116,119 @@ func fingerprint(cert *x509.Certificate) string {
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
 
-func (cs *CertStore) pin(host string, cert *x509.Certificate) error {
-	if cs.Certificates == nil {
-		cs.Certificates = make(map[string]string)
+func keyFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return fmt.Sprintf("%x", sum[:])
+}
+
+// matches reports whether cert is the pinned certificate
+func (p *Pin) matches(cert *x509.Certificate) bool {
+	if p.Fingerprint != "" {
+		return p.Fingerprint == fingerprint(cert)
 	}
-	cs.Certificates[host] = fingerprint(cert)
+	return p.KeyFingerprint != "" && p.KeyFingerprint == keyFingerprint(cert)
+}
+
+func (cs *CertStore) pin(key string, cert *x509.Certificate) error {
+	if cs.Hosts == nil {
+		cs.Hosts = make(map[string]*Pin)
+	}
+	p := NewPin(cert)
+	cs.Hosts[key] = &p
 	return cs.save()
 }
 
-func (cs *CertStore) Check(host string, cert *x509.Certificate, skipVerify bool) error {
+// Check verifies cert is the certificate pinned for host and port, hosts seen for the first time are pinned.
+// A changed certificate is accepted when the pinned one has expired, renewed reports when that happened.
+// Otherwise a *CertChangedError is returned, unless the user decided to trust the certificate.
+func (cs *CertStore) Check(host, port string, cert *x509.Certificate, trust Trust) (renewed bool, err error) {
 	cs.lock.Lock()
 	defer cs.lock.Unlock()
 
-	c, ok := cs.Certificates[host]
-	if !ok || skipVerify {
-		return cs.pin(host, cert)
+	key := net.JoinHostPort(host, port)
+	p, ok := cs.Hosts[key]
+	if !ok {
+		return false, cs.pin(key, cert)
+	}
+	if trust.Fingerprint != "" && trust.Fingerprint == fingerprint(cert) {
+		if trust.Permanent {
+			return false, cs.pin(key, cert)
+		}
+		return false, nil
 	}
 
-	if c != fingerprint(cert) {
-		return CertChanged
+	now := time.Now()
+	if p.matches(cert) {
+		if p.Subject == "" { // Pinned by an older version or imported, fill in the details
+			np := NewPin(cert)
+			np.FirstSeen = p.FirstSeen
+			*p = np
+			return false, cs.save()
+		}
+		if now.Sub(p.LastSeen) > seenInterval {
+			p.LastSeen = now
+			p.NotAfter = cert.NotAfter
+			return false, cs.save()
+		}
+		return false, nil
 	}
-	return nil
+	if !p.NotAfter.IsZero() && now.After(p.NotAfter) {
+		log.Printf("certificate of %s changed after the pinned one expired on %s", key, p.NotAfter)
+		return true, cs.pin(key, cert)
+	}
+	return false, &CertChangedError{Host: key, Pinned: *p, Cert: cert}
+}
+
+// HostKey returns the key of the pin of host, the port defaults to 1965
+func HostKey(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "1965")
+}
+
+// Pins returns a copy of all pins by host:port
+func (cs *CertStore) Pins() map[string]Pin {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	pins := make(map[string]Pin, len(cs.Hosts))
+	for key, p := range cs.Hosts {
+		pins[key] = *p
+	}
+	return pins
+}
+
+// Remove forgets the pin of host:port, so the next certificate seen for it will be trusted
+func (cs *CertStore) Remove(key string) error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if _, ok := cs.Hosts[key]; !ok {
+		return fmt.Errorf("no certificate pinned for %s: %w", key, NotFound)
+	}
+	delete(cs.Hosts, key)
+	return cs.save()
+}
+
+// Import adds pins for hosts that have no pin yet and returns how many were added
+func (cs *CertStore) Import(pins map[string]Pin) (int, error) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if cs.Hosts == nil {
+		cs.Hosts = make(map[string]*Pin)
+	}
+	var added int
+	for key, p := range pins {
+		if _, ok := cs.Hosts[key]; ok {
+			continue
+		}
+		p := p
+		cs.Hosts[key] = &p
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, cs.save()
 }
 
 func (cs *CertStore) save() error {
@@ -73,5 +256,23 @@ func Load(savePath string) (*CertStore, error) {
 	if err := json.NewDecoder(f).Decode(&cs); err != nil {
 		return nil, fmt.Errorf("could not decode certs: %w", err)
 	}
+	cs.migrate()
 	return &cs, nil
 }
+
+// migrate moves pins of older versions, which did not store the port, to the default port
+func (cs *CertStore) migrate() {
+	if len(cs.Certificates) == 0 {
+		return
+	}
+	if cs.Hosts == nil {
+		cs.Hosts = make(map[string]*Pin)
+	}
+	for host, fp := range cs.Certificates {
+		key := net.JoinHostPort(host, "1965")
+		if _, ok := cs.Hosts[key]; !ok {
+			cs.Hosts[key] = &Pin{Fingerprint: fp}
+		}
+	}
+	cs.Certificates = nil
+}
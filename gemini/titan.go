@@ -0,0 +1,49 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Upload describes data to send to a server with the Titan protocol
+type Upload struct {
+	Data io.Reader
+	Size int64
+	// MediaType of the data, it defaults to text/gemini
+	MediaType string
+	// Token authorizes the upload, it is only sent when set
+	Token string
+}
+
+// TitanURL returns the titan URL to upload to the page at a gemini URL
+func TitanURL(surl url.URL) url.URL {
+	surl.Scheme = "titan"
+	surl.RawQuery = ""
+	surl.ForceQuery = false
+	surl.Fragment = ""
+	return surl
+}
+
+// Upload sends data to the titan URL surl
+// The response is handled like the response to a Gemini request, its body can be read from Response.Stream.
+func (client *Client) Upload(ctx context.Context, surl url.URL, upload Upload, trust Trust) (*Response, error) {
+	if surl.Scheme != "titan" {
+		return nil, fmt.Errorf("non-titan scheme %q", surl.Scheme)
+	}
+	if surl.Path == "" {
+		surl.Path = "/"
+	}
+	mediaType := upload.MediaType
+	if mediaType == "" {
+		mediaType = "text/gemini"
+	}
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s;mime=%s;size=%d", surl.String(), mediaType, upload.Size)
+	if upload.Token != "" {
+		fmt.Fprintf(&line, ";token=%s", url.PathEscape(upload.Token))
+	}
+	return client.request(ctx, surl, line.String(), io.LimitReader(upload.Data, upload.Size), trust)
+}
@@ -0,0 +1,101 @@
+package gemini
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// VerifyMode is how the certificate of a server should be verified
+type VerifyMode string
+
+const (
+	// VerifyTOFU trusts the certificate seen on the first visit of a host
+	VerifyTOFU VerifyMode = "tofu"
+	// VerifyCA requires a certificate signed by a certificate authority trusted by the system
+	VerifyCA VerifyMode = "ca"
+	// VerifyCAElseTOFU accepts certificates signed by a certificate authority and falls back to TOFU
+	VerifyCAElseTOFU VerifyMode = "ca-else-tofu"
+)
+
+// ParseVerifyMode parses the name of a verify mode as used in VerifyMode
+func ParseVerifyMode(s string) (VerifyMode, error) {
+	switch m := VerifyMode(s); m {
+	case VerifyTOFU, VerifyCA, VerifyCAElseTOFU:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown verify mode %q (must be %s, %s or %s)", s, VerifyTOFU, VerifyCA, VerifyCAElseTOFU)
+}
+
+// VerifyPolicy decides per host how server certificates are verified
+// The zero value uses TOFU for all hosts.
+type VerifyPolicy struct {
+	Default VerifyMode
+	// Hosts overrides the default mode by hostname
+	Hosts map[string]VerifyMode
+}
+
+// ParseVerifyHosts parses a comma separated list of host=mode pairs
+func ParseVerifyHosts(s string) (map[string]VerifyMode, error) {
+	hosts := make(map[string]VerifyMode)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid host verify mode %q (must be host=mode)", part)
+		}
+		m, err := ParseVerifyMode(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		hosts[strings.ToLower(kv[0])] = m
+	}
+	return hosts, nil
+}
+
+// For returns the verify mode of host
+func (p VerifyPolicy) For(host string) VerifyMode {
+	if m, ok := p.Hosts[strings.ToLower(host)]; ok {
+		return m
+	}
+	if p.Default == "" {
+		return VerifyTOFU
+	}
+	return p.Default
+}
+
+// Verification is how the certificate of a server has been verified
+type Verification uint8
+
+const (
+	NotVerified Verification = iota
+	VerifiedTOFU
+	VerifiedCA
+)
+
+func (v Verification) String() string {
+	switch v {
+	case VerifiedTOFU:
+		return "TOFU"
+	case VerifiedCA:
+		return "CA verified"
+	default:
+		return ""
+	}
+}
+
+// verifyChain verifies the certificates sent by a server against the certificate authorities of the system
+func verifyChain(host string, certs []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: intermediates,
+	})
+	return err
+}
@@ -16,6 +16,17 @@ type Response struct {
 	Data []byte
 	Type byte
 	URL  string
+	// Stream is only set by StreamURL
+	Stream io.ReadCloser
+}
+
+// IsBinary reports whether items of the given type should be downloaded instead of displayed
+func IsBinary(typ byte) bool {
+	switch typ {
+	case '9', 'I', 'g', 's':
+		return true
+	}
+	return false
 }
 
 const TextWidth = 80
@@ -38,7 +49,11 @@ func ToANSI(data []byte, typ byte) (s string, links text.Links) {
 		switch line[0] {
 		case 'i':
 			fmt.Fprintf(&buf, "%s\n", text.Color(f[0], text.Ch2))
-		case '1', '0', 'h':
+		case '1', '0', 'h', '9', 'I', 'g', 's':
+			if len(f) < 4 {
+				fmt.Fprintf(&buf, "%s\n", f[0])
+				break
+			}
 			var url string
 			external := strings.HasPrefix(f[1], "URL:")
 			if external {
@@ -56,6 +71,12 @@ func ToANSI(data []byte, typ byte) (s string, links text.Links) {
 				fmt.Fprintln(&buf, " (text)")
 			case line[0] == 'h':
 				fmt.Fprintln(&buf, " (html)")
+			case line[0] == '9':
+				fmt.Fprintln(&buf, " (binary)")
+			case line[0] == 'I', line[0] == 'g':
+				fmt.Fprintln(&buf, " (image)")
+			case line[0] == 's':
+				fmt.Fprintln(&buf, " (sound)")
 			default:
 				fmt.Fprintln(&buf)
 			}
@@ -68,6 +89,23 @@ func ToANSI(data []byte, typ byte) (s string, links text.Links) {
 }
 
 func LoadURL(ctx context.Context, url neturl.URL) (*Response, error) {
+	resp, err := StreamURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Stream.Close()
+	data, err := io.ReadAll(resp.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("error in gopher response: %w", err)
+	}
+	resp.Data = data
+	resp.Stream = nil
+	return resp, nil
+}
+
+// StreamURL sends the request for url and returns a response with the unread data in Stream,
+// which the caller must close. ctx is only used for connecting.
+func StreamURL(ctx context.Context, url neturl.URL) (*Response, error) {
 	log.Printf("gopher load: %s", url.String())
 	if url.Port() == "" {
 		url.Host += ":70"
@@ -86,11 +124,9 @@ func LoadURL(ctx context.Context, url neturl.URL) (*Response, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not dial gopher %q: %w", url.Host, err)
 	}
-	defer conn.Close()
-	fmt.Fprintf(conn, "%s\r\n", path)
-	data, err := io.ReadAll(io.LimitReader(conn, 1024*1024))
-	if err != nil {
-		return nil, fmt.Errorf("error in gopher response: %w", err)
+	if _, err := fmt.Fprintf(conn, "%s\r\n", path); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not send gopher request: %w", err)
 	}
-	return &Response{Data: data, URL: url.String(), Type: typ}, nil
+	return &Response{URL: url.String(), Type: typ, Stream: conn}, nil
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	neturl "net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sr.ht/~rafael/gembro/gemini"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const identitiesURL = "identities://"
+
+// identitiesPageURL returns the URL of the identities page for managing the identities of url
+func identitiesPageURL(url string) string {
+	if url == "" || strings.HasPrefix(url, identitiesURL) {
+		return identitiesURL
+	}
+	return fmt.Sprintf("%s?url=%s", identitiesURL, neturl.QueryEscape(url))
+}
+
+// identityAction returns the URL of a link on the identities page that performs action
+func identityAction(action, name, pageURL string, extra ...string) string {
+	q := neturl.Values{}
+	if name != "" {
+		q.Set("name", name)
+	}
+	if pageURL != "" {
+		q.Set("url", pageURL)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		q.Set(extra[i], extra[i+1])
+	}
+	return fmt.Sprintf("%s%s?%s", identitiesURL, action, q.Encode())
+}
+
+func identitiesContent(tab Tab, url string) string {
+	var pageURL string
+	var page *neturl.URL
+	if u, err := neturl.Parse(url); err == nil {
+		pageURL = u.Query().Get("url")
+		if strings.ContainsAny(pageURL, "\r\n") {
+			pageURL = "" // It would add lines, like action links, to the page
+		}
+		page, _ = neturl.Parse(pageURL)
+	}
+	ids := tab.client.Identities()
+
+	var buf strings.Builder
+	fmt.Fprint(&buf, "# Identities\n\n")
+	fmt.Fprint(&buf, "Identities are client certificates. "+
+		"They are only sent to the pages they are used on.\n\n")
+	if page != nil && pageURL != "" {
+		fmt.Fprintf(&buf, "Current page: %s\n", pageURL)
+		if id := ids.For(*page); id != nil {
+			fmt.Fprintf(&buf, "Active identity: %s\n", id.Name)
+		} else {
+			fmt.Fprint(&buf, "Active identity: none\n")
+		}
+		fmt.Fprintln(&buf)
+	}
+	fmt.Fprintf(&buf, "=> %s Create a new identity\n", identityAction("new", "", pageURL))
+
+	for _, id := range ids.All() {
+		fmt.Fprintf(&buf, "\n## %s\n\n", id.Name)
+		if cert := id.Certificate(); cert != nil {
+			expiry := cert.NotAfter.Format("2006-01-02")
+			if time.Now().After(cert.NotAfter) {
+				expiry += " (expired)"
+			}
+			fmt.Fprintf(&buf, "Key: %s, expires: %s\n", gemini.KeyTypeOf(cert), expiry)
+		} else {
+			fmt.Fprint(&buf, "The certificate could not be loaded\n")
+		}
+		if len(id.Prefixes) == 0 {
+			fmt.Fprint(&buf, "Not used anywhere\n")
+		}
+		for _, prefix := range id.Prefixes {
+			fmt.Fprintf(&buf, "=> %s Stop using on %s\n",
+				identityAction("stop", id.Name, pageURL, "prefix", prefix), prefix)
+		}
+		if page != nil && pageURL != "" {
+			for _, prefix := range []string{gemini.HostPrefix(*page), pageURL} {
+				if contains(id.Prefixes, prefix) {
+					continue
+				}
+				fmt.Fprintf(&buf, "=> %s Use on %s\n",
+					identityAction("use", id.Name, pageURL, "prefix", prefix), prefix)
+			}
+		}
+		fmt.Fprintf(&buf, "=> %s Regenerate certificate\n", identityAction("regenerate", id.Name, pageURL))
+		fmt.Fprintf(&buf, "=> %s Rename\n", identityAction("rename", id.Name, pageURL))
+		fmt.Fprintf(&buf, "=> %s Export\n", identityAction("export", id.Name, pageURL))
+		fmt.Fprintf(&buf, "=> %s Delete\n", identityAction("delete", id.Name, pageURL))
+	}
+	return buf.String()
+}
+
+// handleIdentityAction performs the action of a link on the identities page
+// Actions are refused from other pages, their links could bind an identity to any host.
+func (tab Tab) handleIdentityAction(u *neturl.URL) (Tab, tea.Cmd) {
+	if !strings.HasPrefix(tab.viewport.URL, identitiesURL) {
+		return tab.showMessage(fmt.Sprintf("Identity actions can only be used on the identities page (%s).",
+			identitiesURL), "", messagePlain, false)
+	}
+	ids := tab.client.Identities()
+	q := u.Query()
+	name, prefix := q.Get("name"), q.Get("prefix")
+	var err error
+	switch u.Host {
+	case "new":
+		return tab.showInput("Name of the new identity", "", u.String(), inputIdentityNew)
+	case "rename":
+		return tab.showInput(fmt.Sprintf("New name for identity %q", name), name, u.String(), inputIdentityRename)
+	case "export":
+		path := filepath.Join(tab.opts.DownloadDir, name+".pem")
+		return tab.showInput(fmt.Sprintf("Export identity %q to", name), path, u.String(),
+			inputIdentityExport)
+	case "delete":
+		return tab.showMessage(fmt.Sprintf("Delete identity %q?\nThis can not be undone.", name),
+			u.String(), messageConfirmIdentity, true)
+	case "regenerate":
+		return tab.showMessage(fmt.Sprintf("Regenerate the certificate of identity %q?\n"+
+			"Capsules will no longer recognize it, this can not be undone.", name),
+			u.String(), messageConfirmIdentity, true)
+	case "use":
+		err = ids.Bind(name, prefix)
+	case "stop":
+		err = ids.Unbind(prefix)
+	default:
+		err = fmt.Errorf("unknown identity action %q", u.Host)
+	}
+	if err != nil {
+		return tab.showMessage(err.Error(), "", messagePlain, false)
+	}
+	return tab.reloadIdentities(u)
+}
+
+// completeIdentityAction completes an action of the identities page that needed input or confirmation
+func (tab Tab) completeIdentityAction(action, value string) (Tab, tea.Cmd) {
+	u, err := neturl.Parse(action)
+	if err != nil {
+		log.Print(err)
+		return tab, nil
+	}
+	ids := tab.client.Identities()
+	name := u.Query().Get("name")
+	value = strings.TrimSpace(value)
+	switch u.Host {
+	case "new":
+		_, err = ids.Create(value, tab.opts.certificateOptions(value))
+	case "rename":
+		err = ids.Rename(name, value)
+	case "export":
+		err = ids.Export(name, filepath.Clean(value))
+	case "delete":
+		err = ids.Delete(name)
+	case "regenerate":
+		err = ids.Regenerate(name, tab.opts.Certificate.Validity)
+	}
+	if err != nil {
+		return tab.showMessage(err.Error(), "", messagePlain, false)
+	}
+	return tab.reloadIdentities(u)
+}
+
+func (tab Tab) reloadIdentities(action *neturl.URL) (Tab, tea.Cmd) {
+	url := identitiesPageURL(action.Query().Get("url"))
+	return tab.loadURL(url, tab.viewport.viewport.YOffset, false, 1, gemini.Trust{}, false)
+}
@@ -12,6 +12,8 @@ type Input struct {
 	Type    int
 	input   textinput.Model
 	Payload string
+	// Multiline offers to continue in the multi-line editor
+	Multiline bool
 }
 
 func NewInput() Input {
@@ -28,6 +30,8 @@ func NewInput() Input {
 
 func (inp Input) Show(msg, val, payload string, typ int) Input {
 	inp.input.Focus()
+	inp.input.EchoMode = textinput.EchoNormal
+	inp.Multiline = false
 	inp.Message = msg
 	inp.Type = typ
 	inp.Payload = payload
@@ -36,6 +40,13 @@ func (inp Input) Show(msg, val, payload string, typ int) Input {
 	return inp
 }
 
+// ShowMasked shows an input for sensitive values like passwords, the value is not displayed
+func (inp Input) ShowMasked(msg, payload string, typ int) Input {
+	inp = inp.Show(msg, "", payload, typ)
+	inp.input.EchoMode = textinput.EchoPassword
+	return inp
+}
+
 func (inp Input) Update(msg tea.Msg) (Input, tea.Cmd) {
 	var cmds []tea.Cmd
 	var cmd tea.Cmd
@@ -48,6 +59,12 @@ func (inp Input) Update(msg tea.Msg) (Input, tea.Cmd) {
 		case "esc":
 			inp.input.Blur()
 			cmds = append(cmds, fireEvent(CloseInputEvent{}))
+		case "ctrl+e":
+			if inp.Multiline {
+				inp.input.Blur()
+				return inp, fireEvent(ShowEditorEvent{Message: inp.Message, Value: inp.input.Value(),
+					Type: inp.Type, Payload: inp.Payload})
+			}
 		}
 	}
 
@@ -57,5 +74,9 @@ func (inp Input) Update(msg tea.Msg) (Input, tea.Cmd) {
 }
 
 func (inp Input) View() string {
-	return fmt.Sprintf("%s %s\n\nPress ENTER to continue or Escape to cancel", inp.Message, inp.input.View())
+	help := "Press ENTER to continue or Escape to cancel"
+	if inp.Multiline {
+		help += ", CTRL+E for a multi-line editor"
+	}
+	return fmt.Sprintf("%s %s\n\n%s", inp.Message, inp.input.View(), help)
 }
@@ -38,6 +38,18 @@ func (bs *Store) Remove(surl string) error {
 	return bs.save()
 }
 
+// Replace points the bookmarks of oldURL to newURL, keeping their names
+func (bs *Store) Replace(oldURL, newURL string) error {
+	bs.Lock()
+	defer bs.Unlock()
+	for i, b := range bs.bookmarks {
+		if b.URL == oldURL {
+			bs.bookmarks[i].URL = newURL
+		}
+	}
+	return bs.save()
+}
+
 func (bs *Store) Contains(surl string) bool {
 	bs.Lock()
 	defer bs.Unlock()
@@ -28,6 +28,41 @@ func (h *History) Add(surl string) {
 	h.pos = len(h.urls) - 1
 }
 
+// RemoveCurrent removes the current entry if it is surl, the entry before it becomes the current one
+func (h *History) RemoveCurrent(surl string) {
+	h.Lock()
+	defer h.Unlock()
+	if len(h.urls) == 0 || h.urls[h.pos].url != surl {
+		return
+	}
+	h.urls = append(h.urls[:h.pos], h.urls[h.pos+1:]...)
+	if h.pos > 0 {
+		h.pos--
+	}
+}
+
+// Replace replaces oldURL by newURL, entries that become the same as the one before are merged
+func (h *History) Replace(oldURL, newURL string) {
+	h.Lock()
+	defer h.Unlock()
+	var urls []URL
+	pos := h.pos
+	for i, u := range h.urls {
+		if u.url == oldURL {
+			u.url = newURL
+		}
+		if len(urls) > 0 && urls[len(urls)-1].url == u.url {
+			if i <= h.pos {
+				pos--
+			}
+			continue
+		}
+		urls = append(urls, u)
+	}
+	h.urls = urls
+	h.pos = pos
+}
+
 func (h *History) Back() (string, int, bool) {
 	h.Lock()
 	defer h.Unlock()
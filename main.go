@@ -2,21 +2,19 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math/big"
-	"math/rand"
+	"net"
 	neturl "net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"git.sr.ht/~rafael/gembro/gemini"
@@ -37,8 +35,11 @@ const (
 )
 
 const (
+	// Client certificate of older versions, now imported as an identity
 	certName = "cert.crt"
 	keyName  = "cert.key"
+
+	identitiesDir = "identities"
 )
 
 var builtinBookmarks = []bookmark.Bookmark{
@@ -52,6 +53,31 @@ func main() {
 	debug := flag.String("debug-url", "", "Debug an URL")
 	logFile := flag.String("log-file", "", "File to output log to")
 	gen := flag.String("generate-certificate", "", "Generate a client certificate with given name")
+	regen := flag.String("regenerate-certificate", "",
+		"Replace the client certificate with given name by a new one, e.g. when it has expired")
+	keyType := flag.String("key-type", string(gemini.KeyRSA), "Key type of generated client certificates: rsa, ecdsa or ed25519")
+	certDays := flag.Int("cert-days", 5*365, "Number of days generated client certificates are valid")
+	certOrg := flag.String("cert-org", "", "Organization of generated client certificates (default the certificate name)")
+	certEmail := flag.String("cert-email", "", "Email address of generated client certificates")
+	listHosts := flag.Bool("list-hosts", false, "List the hosts with a pinned server certificate")
+	showHost := flag.String("show-host", "", "Show the pinned certificate of a host (host or host:port)")
+	removeHost := flag.String("remove-host", "", "Remove the pinned certificate of a host (host or host:port)")
+	importHosts := flag.String("import-hosts", "",
+		"Import pinned certificates from the known hosts file of Amfora (tofu.toml) or Lagrange (trusted.2.txt)")
+	verify := flag.String("verify", string(gemini.VerifyTOFU),
+		"How to verify server certificates: tofu (trust on first use), ca (certificate authorities only) or ca-else-tofu")
+	verifyHosts := flag.String("verify-hosts", "",
+		"Comma separated host=mode pairs to verify server certificates of some hosts differently, e.g. example.org=ca")
+	redirectHosts := flag.String("redirect-hosts", "",
+		"Comma separated host=mode pairs to follow redirects of some hosts differently: "+
+			"follow (to other hosts without asking) or ask (always)")
+	editor := flag.String("editor", defaultEditor(),
+		"Editor for long replies to input prompts and Titan uploads (default $VISUAL, $EDITOR or vi)")
+	downloadDir := flag.String("download-dir", "", "Directory to save downloaded files in (default ~/Downloads)")
+	maxPageSize := flag.Int64("max-page-size", 4*1024*1024,
+		"Maximum size in bytes of a page to display, larger pages can be downloaded instead")
+	serveDir := flag.String("serve", "", "Serve the files in a directory with Gemini instead of browsing")
+	serveAddr := flag.String("serve-addr", "localhost:1965", "Address to listen on with -serve")
 	flag.Parse()
 
 	var url string
@@ -95,50 +121,209 @@ func main() {
 		log.Printf("cache dir: %s", *cacheDir)
 	}
 
-	if *gen != "" {
-		certFile := filepath.Join(*cacheDir, certName)
-		keyFile := filepath.Join(*cacheDir, keyName)
-		if err := generateCertificate(*gen, certFile, keyFile); err != nil {
+	if *listHosts || *showHost != "" || *removeHost != "" || *importHosts != "" {
+		if err := manageHosts(*cacheDir, *listHosts, *showHost, *removeHost, *importHosts); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-		fmt.Printf("Key file at: %q\nCert file at: %q\nGembro will automatically load this certificate.\n",
-			keyFile, certFile)
 		return
 	}
 
-	if err := run(*cacheDir, url); err != nil {
+	kt, err := gemini.ParseKeyType(*keyType)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *certDays <= 0 {
+		fmt.Printf("invalid number of days certificates are valid: %d (must be more than 0)\n", *certDays)
+		os.Exit(1)
+	}
+	mode, err := gemini.ParseVerifyMode(*verify)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	hostModes, err := gemini.ParseVerifyHosts(*verifyHosts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	redirectModes, err := ParseRedirectHosts(*redirectHosts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *downloadDir == "" {
+		*downloadDir = defaultDownloadDir()
+	}
+	opts := &Options{
+		DownloadDir: *downloadDir,
+		MaxPageSize: *maxPageSize,
+		Certificate: gemini.CertificateOptions{
+			KeyType:      kt,
+			Validity:     time.Duration(*certDays) * 24 * time.Hour,
+			Organization: *certOrg,
+			Email:        *certEmail,
+		},
+		Verify:    gemini.VerifyPolicy{Default: mode, Hosts: hostModes},
+		Redirects: RedirectPolicy{Hosts: redirectModes},
+		Editor:    *editor,
+	}
+
+	if *serveDir != "" {
+		if err := serve(*cacheDir, *serveDir, *serveAddr, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *gen != "" || *regen != "" {
+		if err := manageCertificate(*cacheDir, *gen, *regen, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := run(*cacheDir, url, opts); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
-func generateCertificate(name, certFile, keyFile string) error {
-	if _, err := os.Stat(certFile); !os.IsNotExist(err) {
-		return fmt.Errorf("certificate file already exists at %q", certFile)
+// manageCertificate generates a new client certificate or regenerates an existing one
+func manageCertificate(cacheDir, gen, regen string, opts *Options) error {
+	ids, err := loadIdentities(cacheDir)
+	if err != nil {
+		return err
 	}
-	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
-		return fmt.Errorf("key file already exists at %q", keyFile)
+	name := gen
+	if gen != "" {
+		if _, err := ids.Create(gen, opts.certificateOptions(gen)); err != nil {
+			return fmt.Errorf("could not generate certificate: %w", err)
+		}
+	} else {
+		name = regen
+		if err := ids.Regenerate(regen, opts.Certificate.Validity); err != nil {
+			return fmt.Errorf("could not regenerate certificate: %w", err)
+		}
 	}
+	fmt.Printf("Key file at: %q\nCert file at: %q\n"+
+		"Gembro will offer this identity when a capsule asks for a client certificate.\n",
+		ids.KeyFile(name), ids.CertFile(name))
+	return nil
+}
 
-	rand.Seed(time.Now().UnixNano())
-	err := gemini.GenerateClientCertificate(certFile, keyFile, x509.Certificate{
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().AddDate(5, 0, 0),
-		// you have to generate a different serial number each execution
-		SerialNumber: big.NewInt(rand.Int63()),
-		Subject: pkix.Name{
-			CommonName:   name,
-			Organization: []string{name},
-		},
-		BasicConstraintsValid: true,
-	})
+// manageHosts lists, shows, removes or imports pinned server certificates
+func manageHosts(cacheDir string, list bool, show, remove, importFile string) error {
+	cs, err := gemini.Load(filepath.Join(cacheDir, certsName))
 	if err != nil {
-		return fmt.Errorf("could not generate certificate: %w", err)
+		return err
+	}
+	pins := cs.Pins()
+	// A host without port matches the host on any port
+	matching := func(host string) []string {
+		var keys []string
+		for key := range pins {
+			h, _, _ := net.SplitHostPort(key)
+			if key == host || h == host {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	switch {
+	case list:
+		var keys []string
+		for key := range pins {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tEXPIRES\tLAST SEEN")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, formatDate(pins[key].NotAfter), formatDate(pins[key].LastSeen))
+		}
+		return w.Flush()
+	case show != "":
+		keys := matching(show)
+		if len(keys) == 0 {
+			return fmt.Errorf("no certificate pinned for %s", show)
+		}
+		for i, key := range keys {
+			if i > 0 {
+				fmt.Println()
+			}
+			p := pins[key]
+			fmt.Println(key)
+			var buf strings.Builder
+			describePin(&buf, p)
+			fmt.Print(buf.String())
+			if p.KeyFingerprint != "" {
+				fmt.Printf("  Key SHA-256: %s\n", p.KeyFingerprint)
+			}
+			fmt.Printf("  First seen:  %s\n  Last seen:   %s\n", formatDate(p.FirstSeen), formatDate(p.LastSeen))
+		}
+	case remove != "":
+		keys := matching(remove)
+		if len(keys) == 0 {
+			return fmt.Errorf("no certificate pinned for %s", remove)
+		}
+		for _, key := range keys {
+			if err := cs.Remove(key); err != nil {
+				return err
+			}
+			fmt.Printf("Removed pinned certificate of %s\n", key)
+		}
+	case importFile != "":
+		f, err := os.Open(importFile)
+		if err != nil {
+			return fmt.Errorf("could not open known hosts file: %w", err)
+		}
+		defer f.Close()
+		imported, err := gemini.ParseKnownHosts(f)
+		if err != nil {
+			return fmt.Errorf("could not import %q: %w", importFile, err)
+		}
+		added, err := cs.Import(imported)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d of %d hosts, hosts with a pinned certificate were skipped\n", added, len(imported))
 	}
 	return nil
 }
 
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format("2006-01-02")
+}
+
+// loadIdentities loads the client certificates from the cache dir
+// A certificate generated by an older version is imported as the identity "default".
+func loadIdentities(cacheDir string) (*gemini.Identities, error) {
+	ids, err := gemini.LoadIdentities(filepath.Join(cacheDir, identitiesDir))
+	if err != nil {
+		return nil, err
+	}
+	certFile := filepath.Join(cacheDir, certName)
+	keyFile := filepath.Join(cacheDir, keyName)
+	if _, err := os.Stat(certFile); err != nil {
+		return ids, nil
+	}
+	if _, err := ids.Import("default", certFile, keyFile); err != nil {
+		log.Printf("could not import client certificate: %s", err)
+	} else {
+		log.Printf("imported client certificate %q as identity \"default\"", certFile)
+	}
+	return ids, nil
+}
+
 func debugURL(cacheDir, url string) error {
 	u, err := neturl.Parse(url)
 	if err != nil {
@@ -147,35 +332,50 @@ func debugURL(cacheDir, url string) error {
 	if u.Scheme != "gemini" {
 		return fmt.Errorf("non-gemini scheme")
 	}
-	client, err := gemini.NewClient(filepath.Join(cacheDir, certsName), nil)
+	client, err := gemini.NewClient(filepath.Join(cacheDir, certsName), nil, gemini.VerifyPolicy{})
 	if err != nil {
 		return err
 	}
 	fmt.Printf("Start loading %q\n", url)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
-	resp, err := client.LoadURL(ctx, *u, false)
+	resp, err := client.LoadURL(ctx, *u, gemini.Trust{})
 	if err != nil {
 		return err
 	}
 
 	fmt.Println(resp.Header)
+	fmt.Printf("Certificate: %s\n", resp.Verification)
 	return nil
 }
 
-func run(cacheDir, url string) error {
-	certFile := filepath.Join(cacheDir, certName)
-	keyFile := filepath.Join(cacheDir, keyName)
-	ccert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	var cert *tls.Certificate
+// Options are settings shared by all tabs
+type Options struct {
+	DownloadDir string
+	MaxPageSize int64
+	Certificate gemini.CertificateOptions
+	Verify      gemini.VerifyPolicy
+	Redirects   RedirectPolicy
+	Editor      string
+}
+
+// certificateOptions returns the options to generate the client certificate of a new identity
+func (opts *Options) certificateOptions(name string) gemini.CertificateOptions {
+	co := opts.Certificate
+	co.CommonName = name
+	if co.Organization == "" {
+		co.Organization = name
+	}
+	return co
+}
+
+func run(cacheDir, url string, opts *Options) error {
+	ids, err := loadIdentities(cacheDir)
 	if err != nil {
-		log.Printf("did not load client certificate: %s", err)
-		log.Printf("generate certificate with -generate-certificate if client certificate is needed")
-	} else {
-		cert = &ccert
+		return err
 	}
 
-	client, err := gemini.NewClient(filepath.Join(cacheDir, certsName), cert)
+	client, err := gemini.NewClient(filepath.Join(cacheDir, certsName), ids, opts.Verify)
 	if err != nil {
 		return err
 	}
@@ -186,11 +386,12 @@ func run(cacheDir, url string) error {
 	}
 
 	historyPath := filepath.Join(cacheDir, "history.json")
-	tabs, seqID, err := loadTabs(historyPath, client, bs, url)
+	tabs, seqID, err := loadTabs(historyPath, client, bs, url, opts)
 	if err != nil {
 		return err
 	}
 	p := tea.NewProgram(model{
+		opts:        opts,
 		client:      client,
 		bookmarks:   bs,
 		sequenceID:  seqID,
@@ -211,6 +412,8 @@ const (
 	modePage mode = iota
 	modeInput
 	modeMessage
+	modeEditor
+	modeOutline
 )
 
 type TabEvent interface {
@@ -225,6 +428,7 @@ type model struct {
 	client        *gemini.Client
 	bookmarks     *bookmark.Store
 	sequenceID    tabID
+	opts          *Options
 }
 
 type QuitEvent struct{}
@@ -287,6 +491,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, fireEvent(CloseTabEvent{Tab: m.currentTab})
 	case CloseTabEvent:
 		if msg.Tab < len(m.tabs) && len(m.tabs) > 1 {
+			if cancel := m.tabs[msg.Tab].cancel; cancel != nil {
+				cancel() // Stop loading the page of the tab
+			}
 			m.tabs = append(m.tabs[0:msg.Tab], m.tabs[msg.Tab+1:]...)
 			for m.currentTab >= len(m.tabs) {
 				m.currentTab--
@@ -317,7 +524,7 @@ func (m model) openNewTab(url string, switchTo bool) (model, tea.Cmd) {
 	var cmd tea.Cmd
 	if len(m.tabs) < 9 {
 		m.sequenceID++
-		m.tabs = append(m.tabs, NewTab(m.client, url, 0, m.bookmarks, nil, m.sequenceID))
+		m.tabs = append(m.tabs, NewTab(m.client, url, 0, m.bookmarks, nil, m.sequenceID, m.opts))
 		if switchTo {
 			cmd = fireEvent(SelectTabEvent{Tab: len(m.tabs) - 1})
 		}
@@ -335,6 +542,8 @@ type GoBackEvent struct{}
 type GoForwardEvent struct{}
 type EditSourceEvent struct{}
 
+type UploadEvent struct{}
+
 type ToggleBookmarkEvent struct {
 	URL, Title string
 }
@@ -365,13 +574,14 @@ func (m model) saveHistory(path string) error {
 	return nil
 }
 
-func loadTabs(historyPath string, client *gemini.Client, bs *bookmark.Store, startURL string) ([]Tab, tabID, error) {
+func loadTabs(historyPath string, client *gemini.Client, bs *bookmark.Store, startURL string,
+	opts *Options) ([]Tab, tabID, error) {
 	seqID := tabID(1)
 	f, err := os.Open(historyPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []Tab{
-				NewTab(client, startURL, 0, bs, nil, seqID),
+				NewTab(client, startURL, 0, bs, nil, seqID, opts),
 			}, seqID + 1, nil
 		}
 		return nil, 0, fmt.Errorf("could not load history file: %w", err)
@@ -382,7 +592,7 @@ func loadTabs(historyPath string, client *gemini.Client, bs *bookmark.Store, sta
 	if err != nil {
 		log.Printf("Incompatible history file. Ignoring it.")
 		return []Tab{
-			NewTab(client, startURL, 0, bs, nil, seqID),
+			NewTab(client, startURL, 0, bs, nil, seqID, opts),
 		}, seqID + 1, nil
 	}
 	var tabs []Tab
@@ -392,7 +602,7 @@ func loadTabs(historyPath string, client *gemini.Client, bs *bookmark.Store, sta
 		if u == "" {
 			u, scrollPos = h.Current()
 		}
-		tab := NewTab(client, u, scrollPos, bs, h, seqID)
+		tab := NewTab(client, u, scrollPos, bs, h, seqID, opts)
 		tabs = append(tabs, tab)
 		seqID++
 	}
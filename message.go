@@ -14,19 +14,40 @@ type Message struct {
 	WithConfirm bool
 	Payload     string
 	actionY     int
+	Choices     []string
 }
 
 func NewMessage(message string, typ int, withConfirm bool, payload string) Message {
 	msg := text.Wrap(message, 80)
 	actionY := strings.Count(msg, "\n") + headerHeight + 1
-	return Message{msg, typ, withConfirm, payload, actionY}
+	return Message{msg, typ, withConfirm, payload, actionY, nil}
+}
+
+// NewChoiceMessage returns a message with a button for each choice
+// A choice is made by clicking it or typing its first letter, escape cancels.
+func NewChoiceMessage(message string, typ int, payload string, choices ...string) Message {
+	m := NewMessage(message, typ, false, payload)
+	m.Choices = choices
+	return m
 }
 
 func (m Message) Update(msg tea.Msg) (Message, tea.Cmd) {
 	var cmds []tea.Cmd
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch skey := msg.String(); skey {
+		skey := msg.String()
+		if len(m.Choices) > 0 {
+			for _, c := range m.Choices {
+				if skey == strings.ToLower(c[:1]) {
+					return m, fireEvent(MessageEvent{Response: true, Choice: c, Type: m.Type, Payload: m.Payload})
+				}
+			}
+			if skey == "q" || skey == "esc" {
+				return m, fireEvent(MessageEvent{Response: false, Type: m.Type, Payload: m.Payload})
+			}
+			return m, nil
+		}
+		switch skey {
 		case "y", "n", "enter", "q", "esc":
 			cmds = append(cmds, fireEvent(MessageEvent{Response: skey == "y", Type: m.Type, Payload: m.Payload}))
 		}
@@ -40,6 +61,17 @@ func (m Message) handleClick(msg tea.MouseMsg) tea.Cmd {
 	if msg.Y != m.actionY {
 		return nil
 	}
+	if len(m.Choices) > 0 {
+		var x int
+		for _, c := range m.Choices {
+			start := x + 1
+			x = start + len(c) + 2
+			if start <= msg.X && msg.X < start+len(c) {
+				return fireEvent(MessageEvent{Response: true, Choice: c, Type: m.Type, Payload: m.Payload})
+			}
+		}
+		return nil
+	}
 	if m.WithConfirm {
 		yes := 1 <= msg.X && msg.X < 4
 		no := 10 <= msg.X && msg.X < 12
@@ -55,6 +87,13 @@ func (m Message) handleClick(msg tea.MouseMsg) tea.Cmd {
 }
 
 func (m Message) View() string {
+	if len(m.Choices) > 0 {
+		var buttons []string
+		for _, c := range m.Choices {
+			buttons = append(buttons, fmt.Sprintf("[%s]", text.Color(c, text.Clink)))
+		}
+		return fmt.Sprintf("%s\n\n%s", m.Message, strings.Join(buttons, " "))
+	}
 	if m.WithConfirm {
 		return fmt.Sprintf("%s\n\n[%s] or [%s]",
 			m.Message,
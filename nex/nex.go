@@ -0,0 +1,99 @@
+package nex
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	neturl "net/url"
+	"strings"
+
+	"git.sr.ht/~rafael/gembro/gemini"
+	"git.sr.ht/~rafael/gembro/text"
+)
+
+const TextWidth = 80
+
+type Response struct {
+	Data []byte
+	URL  string
+	// Directory is set when the response is a directory listing
+	Directory bool
+	// Stream is only set by StreamURL
+	Stream io.ReadCloser
+}
+
+// IsDirectory reports whether url points to a directory, the path of directories ends with a slash
+func IsDirectory(url neturl.URL) bool {
+	return url.Path == "" || strings.HasSuffix(url.Path, "/")
+}
+
+// ToANSI converts a nex response to text for terminal output
+// Directory listings are plain text with gemtext link lines, other responses are wrapped text.
+func ToANSI(data []byte, directory bool, baseURL neturl.URL) (s string, links text.Links) {
+	if !directory {
+		return text.Wrap(string(data), TextWidth), links
+	}
+	var buf strings.Builder
+	for ypos, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, "=>") {
+			fmt.Fprintln(&buf, line)
+			continue
+		}
+		l, err := gemini.ParseLink(line)
+		if err != nil {
+			fmt.Fprintln(&buf, line)
+			continue
+		}
+		furl, err := baseURL.Parse(l.URL)
+		if err != nil {
+			fmt.Fprintln(&buf, line)
+			continue
+		}
+		count := links.Count() + 1
+		links.Add(ypos, count, furl.String(), l.Name)
+		fmt.Fprintf(&buf, "%d> %s", count, text.Color(l.Name, text.Clink))
+		if furl.Scheme != "nex" {
+			fmt.Fprintf(&buf, " (%s)", furl.Scheme)
+		}
+		fmt.Fprintln(&buf)
+	}
+	return buf.String(), links
+}
+
+func LoadURL(ctx context.Context, url neturl.URL) (*Response, error) {
+	resp, err := StreamURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Stream.Close()
+	data, err := io.ReadAll(resp.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("error in nex response: %w", err)
+	}
+	resp.Data = data
+	resp.Stream = nil
+	return resp, nil
+}
+
+// StreamURL sends the request for url and returns a response with the unread data in Stream,
+// which the caller must close. ctx is only used for connecting.
+func StreamURL(ctx context.Context, url neturl.URL) (*Response, error) {
+	log.Printf("nex load: %s", url.String())
+	host := url.Host
+	if url.Port() == "" {
+		host = net.JoinHostPort(url.Hostname(), "1900")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial nex %q: %w", host, err)
+	}
+	if _, err := fmt.Fprintf(conn, "%s\r\n", strings.TrimPrefix(url.Path, "/")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not send nex request: %w", err)
+	}
+	return &Response{URL: url.String(), Directory: IsDirectory(url), Stream: conn}, nil
+}
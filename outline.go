@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"git.sr.ht/~rafael/gembro/gemini"
+	"git.sr.ht/~rafael/gembro/text"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/muesli/termenv"
+)
+
+// outlineTop is the screen line of the first heading of the outline, below the tab bar and the title
+const outlineTop = headerHeight + 1
+
+// Outline lists the headings of a page to jump to one of them
+type Outline struct {
+	title     string
+	headings  []gemini.Heading
+	selected  int
+	top       int // first visible heading
+	height    int
+	lastEvent tea.MouseEventType
+}
+
+// NewOutline returns an outline with the heading at or above the line yOffset selected
+func NewOutline(title string, headings []gemini.Heading, yOffset, height int) Outline {
+	o := Outline{title: title, headings: headings, height: height - 3}
+	if o.height < 1 {
+		o.height = 1
+	}
+	for i, h := range headings {
+		if h.YPos <= yOffset {
+			o.selected = i
+		}
+	}
+	o.scroll()
+	return o
+}
+
+func (o *Outline) scroll() {
+	if o.selected < o.top {
+		o.top = o.selected
+	}
+	if o.selected >= o.top+o.height {
+		o.top = o.selected - o.height + 1
+	}
+}
+
+func (o Outline) Update(msg tea.Msg) (Outline, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if o.selected > 0 {
+				o.selected--
+			}
+		case "down", "j":
+			if o.selected < len(o.headings)-1 {
+				o.selected++
+			}
+		case "home":
+			o.selected = 0
+		case "end":
+			if len(o.headings) > 0 {
+				o.selected = len(o.headings) - 1
+			}
+		case "pgup":
+			o.selected -= o.height
+			if o.selected < 0 {
+				o.selected = 0
+			}
+		case "pgdown":
+			o.selected += o.height
+			if o.selected >= len(o.headings) {
+				o.selected = len(o.headings) - 1
+			}
+			if o.selected < 0 {
+				o.selected = 0
+			}
+		case "enter":
+			if o.selected < 0 || o.selected >= len(o.headings) {
+				return o, fireEvent(OutlineEvent{})
+			}
+			return o, fireEvent(OutlineEvent{Selected: true, YPos: o.headings[o.selected].YPos})
+		case "esc", "q", "o":
+			return o, fireEvent(OutlineEvent{})
+		}
+		o.scroll()
+	case tea.MouseMsg:
+		switch msg.Type {
+		case tea.MouseLeft, tea.MouseRight:
+			o.lastEvent = msg.Type
+		case tea.MouseRelease:
+			if o.lastEvent == tea.MouseRight {
+				return o, fireEvent(OutlineEvent{})
+			}
+			i := o.top + msg.Y - outlineTop
+			if o.lastEvent == tea.MouseLeft && msg.Y >= outlineTop && i >= 0 && i < len(o.headings) &&
+				i < o.top+o.height {
+				return o, fireEvent(OutlineEvent{Selected: true, YPos: o.headings[i].YPos})
+			}
+		case tea.MouseWheelUp:
+			if o.top > 0 {
+				o.top--
+			}
+		case tea.MouseWheelDown:
+			if o.top+o.height < len(o.headings) {
+				o.top++
+			}
+		}
+	}
+	return o, nil
+}
+
+func (o Outline) View() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Outline of %s\n\n", o.title)
+	for i := o.top; i < len(o.headings) && i < o.top+o.height; i++ {
+		h := o.headings[i]
+		line := strings.Repeat("  ", h.Level-1) + h.Text
+		if i == o.selected {
+			line = termenv.String(line).Reverse().String()
+		} else {
+			line = text.Color(line, gemini.HeadingColor(h.Level))
+		}
+		fmt.Fprintf(&buf, "%s\n", line)
+	}
+	for i := len(o.headings) - o.top; i < o.height; i++ {
+		buf.WriteString("\n")
+	}
+	fmt.Fprint(&buf, "\nEnter or click to jump to a heading, Escape to close")
+	return buf.String()
+}
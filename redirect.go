@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"git.sr.ht/~rafael/gembro/gemini"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxRedirects is how many redirects are followed in a row
+const maxRedirects = 5
+
+// RedirectMode is how redirects by a host are followed
+type RedirectMode string
+
+const (
+	// RedirectFollow follows redirects to other hosts without asking
+	RedirectFollow RedirectMode = "follow"
+	// RedirectAsk asks before following any redirect, even to the same host
+	RedirectAsk RedirectMode = "ask"
+)
+
+// RedirectPolicy decides which redirects need a confirmation
+// By default redirects to the same host are followed and redirects to another host are confirmed.
+// Redirects to another scheme than gemini are always confirmed.
+type RedirectPolicy struct {
+	// Hosts overrides the default by the hostname that redirects
+	Hosts map[string]RedirectMode
+}
+
+// ParseRedirectHosts parses a comma separated list of host=mode pairs
+func ParseRedirectHosts(s string) (map[string]RedirectMode, error) {
+	hosts := make(map[string]RedirectMode)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid host redirect mode %q (must be host=mode)", part)
+		}
+		switch m := RedirectMode(kv[1]); m {
+		case RedirectFollow, RedirectAsk:
+			hosts[strings.ToLower(kv[0])] = m
+		default:
+			return nil, fmt.Errorf("unknown redirect mode %q (must be %s or %s)", kv[1], RedirectFollow, RedirectAsk)
+		}
+	}
+	return hosts, nil
+}
+
+// Confirm reports whether the redirect from one URL to another should be confirmed
+func (p RedirectPolicy) Confirm(from, to *neturl.URL) bool {
+	if to.Scheme != "gemini" {
+		return true
+	}
+	switch p.Hosts[strings.ToLower(from.Hostname())] {
+	case RedirectFollow:
+		return false
+	case RedirectAsk:
+		return true
+	default:
+		return !strings.EqualFold(from.Host, to.Host)
+	}
+}
+
+// redirect is a redirect waiting for confirmation
+type redirect struct {
+	resp   GeminiResponse
+	target string
+}
+
+// handleRedirect follows the redirect of resp, after a confirmation when the policy asks for one
+func (tab Tab) handleRedirect(resp GeminiResponse) (Tab, tea.Cmd) {
+	if resp.level > maxRedirects {
+		return tab.showMessage("Too many redirects. Welcome to the Web from Hell.", "", messagePlain, false)
+	}
+	from, err := neturl.Parse(resp.URL)
+	source := resp.displayURL()
+	if err != nil {
+		return tab.showMessage(fmt.Sprintf("Invalid URL: %s", err), "", messagePlain, false)
+	}
+	to, err := from.Parse(strings.TrimSpace(resp.Header.Meta))
+	if err != nil {
+		return tab.showMessage(fmt.Sprintf("%s redirects to an invalid URL: %q", source, resp.Header.Meta),
+			"", messagePlain, false)
+	}
+	target := to.String()
+	if !tab.opts.Redirects.Confirm(from, to) {
+		return tab.followRedirect(resp, target)
+	}
+
+	moved := "redirects"
+	if resp.Header.StatusDetail == 1 {
+		moved = "has moved permanently"
+	}
+	if !isInternal(target) {
+		return tab.showMessage(fmt.Sprintf("%s %s to %s\nOpen it externally?", source, moved, target),
+			target, messageLoadExternal, true)
+	}
+	var m string
+	switch {
+	case to.Scheme != from.Scheme:
+		m = fmt.Sprintf("%s %s to a %s URL:\n%s\nFollow the redirect?", source, moved, to.Scheme, target)
+	case !strings.EqualFold(from.Host, to.Host):
+		m = fmt.Sprintf("%s %s to another host:\n%s\nFollow the redirect?", source, moved, target)
+	default:
+		m = fmt.Sprintf("%s %s to:\n%s\nFollow the redirect?", source, moved, target)
+	}
+	tab.redirect = redirect{resp: resp, target: target}
+	return tab.showMessage(m, target, messageRedirect, true)
+}
+
+// movedBookmark is a bookmark of a page that has moved permanently
+type movedBookmark struct {
+	source string
+	target string
+}
+
+// followRedirect loads target, the redirect of resp
+// When the page has moved for good the old URL is replaced by the new one in the history
+// and updating bookmarks is offered after the new page is loaded.
+func (tab Tab) followRedirect(resp GeminiResponse, target string) (Tab, tea.Cmd) {
+	var moved movedBookmark
+	if resp.Header.StatusDetail == 1 {
+		source := resp.displayURL()
+		tab.history.Replace(source, target)
+		if tab.bookmarks.Contains(source) {
+			moved = movedBookmark{source: source, target: target}
+		}
+	}
+	var cmd tea.Cmd
+	tab, cmd = tab.loadURL(target, resp.scrollPos, resp.addHist, resp.level+1, gemini.Trust{}, false)
+	if moved.target != "" {
+		tab.movedBookmark = moved
+	}
+	return tab, cmd
+}
+
+// offerBookmarkMove asks to update the bookmark of a page that has moved, cmd is still run
+func (tab Tab) offerBookmarkMove(cmd tea.Cmd) (Tab, tea.Cmd) {
+	moved := tab.movedBookmark
+	tab.movedBookmark = movedBookmark{}
+	tab, _ = tab.showMessage(fmt.Sprintf("Your bookmark %q has moved permanently to %q.\n"+
+		"Would you like to update the bookmark?", moved.source, moved.target),
+		fmt.Sprintf("%s %s", moved.source, moved.target), messageMoveBookmark, true)
+	return tab, cmd
+}
+
+// isRedirect reports whether resp redirects to another page, which is loaded next
+func isRedirect(resp ServerResponse) bool {
+	switch resp := resp.(type) {
+	case GeminiResponse:
+		return resp.Header.Status == 3
+	case SpartanResponse:
+		return resp.Header.Status == 3
+	}
+	return false
+}
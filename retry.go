@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~rafael/gembro/gemini"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	choiceRetry    = "Retry"
+	choiceRetryNow = "Retry now"
+	choiceCancel   = "Cancel"
+)
+
+// defaultSlowDown is how long to wait when a server asks to slow down without saying how long
+const defaultSlowDown = 10 * time.Second
+
+// retry is a request that failed temporarily
+type retry struct {
+	url       string
+	scrollPos int
+	addHist   bool
+	sensitive bool
+	// at is when a request the server asked to slow down for is retried automatically
+	at time.Time
+}
+
+func (r retry) load(tab Tab) (Tab, tea.Cmd) {
+	return tab.loadURL(r.url, r.scrollPos, r.addHist, 1, gemini.Trust{}, r.sensitive)
+}
+
+// RetryEvent is sent every second while waiting to retry a request the server asked to slow down for
+type RetryEvent struct {
+	tab tabID
+	at  time.Time
+}
+
+func (re RetryEvent) Tab() tabID {
+	return re.tab
+}
+
+func waitForRetry(ev RetryEvent) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return ev
+	})
+}
+
+// handleTemporaryFailure explains a 4x response and offers to retry, after a slow down it retries automatically
+func (tab Tab) handleTemporaryFailure(resp GeminiResponse) (Tab, tea.Cmd) {
+	tab.retry = retry{url: resp.URL, scrollPos: resp.scrollPos, addHist: resp.addHist, sensitive: resp.sensitive}
+	if resp.Header.StatusDetail == 4 {
+		delay := defaultSlowDown
+		if secs, err := strconv.Atoi(strings.TrimSpace(resp.Header.Meta)); err == nil && secs > 0 {
+			delay = time.Duration(secs) * time.Second
+		}
+		tab.retry.at = time.Now().Add(delay)
+		tab.message = NewChoiceMessage(tab.retry.slowDownMessage(), messageRetry, resp.URL,
+			choiceRetryNow, choiceCancel)
+		tab.mode = modeMessage
+		return tab, waitForRetry(RetryEvent{tab: tab.id, at: tab.retry.at})
+	}
+
+	var m string
+	switch resp.Header.StatusDetail {
+	case 1:
+		m = "The server is unavailable, it might be overloaded or down for maintenance."
+	case 2:
+		m = "The server could not run the program that generates this page."
+	case 3:
+		m = "The server could not get this page from another server it acts as a proxy for."
+	default:
+		m = "The server could not load this page right now."
+	}
+	if resp.Header.Meta != "" {
+		m += fmt.Sprintf("\nThe server says: %s", resp.Header.Meta)
+	}
+	m += fmt.Sprintf("\n\n%s might load when you try again later.", resp.displayURL())
+	tab.message = NewChoiceMessage(m, messageRetry, resp.URL, choiceRetry, choiceCancel)
+	tab.mode = modeMessage
+	return tab, nil
+}
+
+func (r retry) slowDownMessage() string {
+	secs := int(time.Until(r.at).Round(time.Second) / time.Second)
+	if secs < 0 {
+		secs = 0
+	}
+	url := r.url
+	if r.sensitive {
+		url = withoutQuery(url)
+	}
+	return fmt.Sprintf("The server asks to slow down.\n\nRetrying %s in %d seconds...", url, secs)
+}
+
+// handleRetry counts down until a request the server asked to slow down for is retried
+func (tab Tab) handleRetry(ev RetryEvent) (Tab, tea.Cmd) {
+	if tab.retry.at.IsZero() || !tab.retry.at.Equal(ev.at) {
+		return tab, nil // Canceled or another page has been loaded in the meantime
+	}
+	if time.Now().Before(ev.at.Add(-time.Second / 2)) {
+		if tab.mode == modeMessage && tab.message.Type == messageRetry {
+			tab.message = NewChoiceMessage(tab.retry.slowDownMessage(), messageRetry, tab.retry.url,
+				choiceRetryNow, choiceCancel)
+		}
+		return tab, waitForRetry(ev)
+	}
+	tab.mode = modePage
+	return tab.retry.load(tab)
+}
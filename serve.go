@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sr.ht/~rafael/gembro/gemini"
+)
+
+const (
+	serverCertName = "server-cert.pem"
+	serverKeyName  = "server-key.pem"
+)
+
+// serveTimeout limits the time to handle a request
+const serveTimeout = 30 * time.Second
+
+// serve serves the files in dir with Gemini on addr until it fails
+// The self-signed certificate is kept in cacheDir, so clients that pinned it keep trusting it.
+func serve(cacheDir, dir, addr string, opts *Options) error {
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("could not serve directory: %w", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("could not serve %q: not a directory", dir)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	cert, err := serverCertificate(cacheDir, host, opts)
+	if err != nil {
+		return err
+	}
+	ln, err := tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+	defer ln.Close()
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	logger.Printf("serving %s on gemini://%s/", dir, ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("could not accept connection: %w", err)
+		}
+		go handleGeminiRequest(conn, dir, logger)
+	}
+}
+
+// serverCertificate loads the server certificate from cacheDir, a new one is generated if it is missing or expired
+func serverCertificate(cacheDir, host string, opts *Options) (tls.Certificate, error) {
+	certFile := filepath.Join(cacheDir, serverCertName)
+	keyFile := filepath.Join(cacheDir, serverKeyName)
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Now().Before(leaf.NotAfter) {
+			return cert, nil
+		}
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	name := "localhost"
+	if host != "" && !contains(hosts, host) {
+		hosts = append(hosts, host)
+		name = host
+	}
+	template, err := gemini.NewServerCertificateTemplate(gemini.CertificateOptions{
+		Validity:   opts.Certificate.Validity,
+		CommonName: name,
+	}, hosts)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not generate server certificate: %w", err)
+	}
+	if err := gemini.GenerateCertificate(certFile, keyFile, opts.Certificate.KeyType, template); err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not generate server certificate: %w", err)
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+func handleGeminiRequest(conn net.Conn, dir string, logger *log.Logger) {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(serveTimeout)); err != nil {
+		logger.Print(err)
+		return
+	}
+	line, err := bufio.NewReader(io.LimitReader(conn, maxURLLength+2)).ReadString('\n')
+	if err != nil {
+		fmt.Fprint(conn, "59 Bad request\r\n")
+		return
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	reply := resolveRequest(line, dir)
+	logger.Printf("%s %q %d %s", conn.RemoteAddr(), line, reply.status, reply.meta)
+	if _, err := fmt.Fprintf(conn, "%d %s\r\n", reply.status, reply.meta); err != nil || reply.status != 20 {
+		return
+	}
+	if err := reply.writeBody(conn); err != nil {
+		logger.Print(err)
+	}
+}
+
+// geminiReply is the response to a request of the server
+type geminiReply struct {
+	status int
+	meta   string
+	file   string // file to send as body
+	index  string // generated body of a directory without index.gmi
+}
+
+// resolveRequest returns the reply to the request line for a file in dir
+func resolveRequest(line, dir string) geminiReply {
+	u, err := neturl.Parse(line)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return geminiReply{status: 59, meta: "Bad request"}
+	}
+	if u.Scheme != "gemini" {
+		return geminiReply{status: 53, meta: "Proxy request refused"}
+	}
+	upath := path.Clean("/" + u.Path)
+	for _, part := range strings.Split(upath, "/") {
+		if strings.HasPrefix(part, ".") {
+			return geminiReply{status: 51, meta: "Not found"}
+		}
+	}
+	name := filepath.Join(dir, filepath.FromSlash(upath))
+	info, err := os.Stat(name)
+	if err != nil {
+		return geminiReply{status: 51, meta: "Not found"}
+	}
+	if !info.IsDir() {
+		mediaType := getMediaType(path.Ext(name))
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		return geminiReply{status: 20, meta: mediaType, file: name}
+	}
+	if upath != "/" {
+		upath += "/"
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		// Relative links of the page resolve inside the directory
+		target := neturl.URL{Path: upath}
+		return geminiReply{status: 31, meta: target.String()}
+	}
+	if index := filepath.Join(name, "index.gmi"); fileExists(index) {
+		return geminiReply{status: 20, meta: "text/gemini", file: index}
+	}
+	index, err := directoryIndex(name, upath)
+	if err != nil {
+		return geminiReply{status: 51, meta: "Not found"}
+	}
+	return geminiReply{status: 20, meta: "text/gemini", index: index}
+}
+
+func (r geminiReply) writeBody(w io.Writer) error {
+	if r.file == "" {
+		_, err := io.WriteString(w, r.index)
+		return err
+	}
+	f, err := os.Open(r.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && !info.IsDir()
+}
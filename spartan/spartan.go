@@ -0,0 +1,151 @@
+package spartan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	neturl "net/url"
+	"strings"
+)
+
+type Header struct {
+	Status uint8
+	Meta   string
+}
+
+func readHeader(in *bufio.Reader) (*Header, error) {
+	line, err := in.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	if len(line) < 2 || line[1] != ' ' {
+		return nil, fmt.Errorf("malformed header")
+	}
+	if '2' > line[0] || line[0] > '5' {
+		return nil, fmt.Errorf("malformed header")
+	}
+	h := Header{Status: line[0] - '0', Meta: line[2:]}
+	if len(h.Meta) > 1024 {
+		return nil, fmt.Errorf("meta too long")
+	}
+	return &h, nil
+}
+
+type Response struct {
+	Header Header
+	URL    string
+	Body   []byte
+	// Stream is only set by StreamURL for successful responses
+	Stream io.ReadCloser
+}
+
+func LoadURL(ctx context.Context, url neturl.URL) (*Response, error) {
+	resp, err := StreamURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Stream == nil {
+		return resp, nil
+	}
+	defer resp.Stream.Close()
+	data, err := io.ReadAll(resp.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("error in spartan response: %w", err)
+	}
+	resp.Body = data
+	resp.Stream = nil
+	return resp, nil
+}
+
+// StreamURL sends the request for url, the query of url is sent as data.
+// The body of a successful response can be read from Response.Stream, which the caller must close.
+// ctx is only used for connecting and reading the header.
+func StreamURL(ctx context.Context, url neturl.URL) (*Response, error) {
+	log.Printf("spartan load: %s", url.String())
+	host := url.Host
+	if url.Port() == "" {
+		host = net.JoinHostPort(url.Hostname(), "300")
+	}
+	path := url.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	data, err := neturl.QueryUnescape(url.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial spartan %q: %w", host, err)
+	}
+	headerRead := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-headerRead:
+		}
+	}()
+	if _, err := fmt.Fprintf(conn, "%s %s %d\r\n%s", url.Hostname(), path, len(data), data); err != nil {
+		close(headerRead)
+		conn.Close()
+		return nil, fmt.Errorf("could not send spartan request: %w", err)
+	}
+	rdr := bufio.NewReader(conn)
+	header, err := readHeader(rdr)
+	close(headerRead)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	url.ForceQuery = false
+	resp := &Response{Header: *header, URL: url.String()}
+	if header.Status != 2 {
+		conn.Close()
+		return resp, nil
+	}
+	resp.Stream = stream{rdr, conn}
+	return resp, nil
+}
+
+type stream struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// ToGemtext turns the prompt lines of a spartan page into gemtext links
+// The URL of a prompt link ends with an empty query, following it asks for the data to send.
+func ToGemtext(data string) string {
+	lines := strings.Split(data, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "=:") {
+			continue
+		}
+		fields := strings.Fields(line[2:])
+		if len(fields) == 0 {
+			continue
+		}
+		url := fields[0]
+		if idx := strings.Index(url, "?"); idx != -1 {
+			url = url[:idx]
+		}
+		name := strings.Join(fields[1:], " ")
+		if name == "" {
+			name = fields[0]
+		}
+		lines[i] = fmt.Sprintf("=> %s? %s (input)", url, name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// IsPrompt reports whether url is the URL of a prompt link, see ToGemtext
+func IsPrompt(url neturl.URL) bool {
+	return url.ForceQuery && url.RawQuery == ""
+}
@@ -1,22 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	neturl "net/url"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"git.sr.ht/~rafael/gembro/finger"
 	"git.sr.ht/~rafael/gembro/gemini"
 	"git.sr.ht/~rafael/gembro/gopher"
 	"git.sr.ht/~rafael/gembro/internal/bookmark"
 	"git.sr.ht/~rafael/gembro/internal/history"
+	"git.sr.ht/~rafael/gembro/nex"
+	"git.sr.ht/~rafael/gembro/spartan"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -25,8 +33,14 @@ import (
 const (
 	inputNav = iota + 1
 	inputQuery
+	inputSensitive
 	inputBookmark
 	inputDownloadSrc
+	inputIdentity
+	inputIdentityNew
+	inputIdentityRename
+	inputIdentityExport
+	inputUploadToken
 )
 
 const (
@@ -34,10 +48,30 @@ const (
 	messageDelBookmark
 	messageLoadExternal
 	messageForceCert
+	messageDownload
+	messageConfirmIdentity
+	messageRetry
+	messageMoveBookmark
+	messageRedirect
+	messageEditorError
+)
+
+const (
+	choiceAcceptOnce = "Accept once"
+	choiceTrust      = "Trust permanently"
+	choiceReject     = "Reject"
 )
 
 type tabID uint64
 
+// internalSchemes are the schemes of URLs loaded in a tab, others are opened externally
+var internalSchemes = []string{"gemini", "gopher", "spartan", "finger", "nex", "file"}
+
+func isInternal(url string) bool {
+	u, err := neturl.Parse(url)
+	return err == nil && contains(internalSchemes, u.Scheme)
+}
+
 const (
 	homeURL = "home://"
 	helpURL = "help://"
@@ -47,6 +81,8 @@ type Tab struct {
 	id           tabID
 	mode         mode
 	input        Input
+	editor       Editor
+	outline      Outline
 	message      Message
 	viewport     Viewport
 	client       *gemini.Client
@@ -54,10 +90,19 @@ type Tab struct {
 	history      *history.History
 	bookmarks    *bookmark.Store
 	lastResponse ServerResponse
-	specialPages map[string]func(Tab) string
+	specialPages map[string]func(tab Tab, url string) string
+	opts         *Options
+	downloads    []DownloadEvent
+	retry        retry
+	redirect     redirect
+	// movedBookmark is offered to be updated once the page it has moved to is loaded
+	movedBookmark movedBookmark
+	// upload is the page being edited and uploaded with Titan
+	upload upload
 }
 
-func NewTab(client *gemini.Client, startURL string, scrollPos int, bs *bookmark.Store, h *history.History, id tabID) Tab {
+func NewTab(client *gemini.Client, startURL string, scrollPos int, bs *bookmark.Store, h *history.History, id tabID,
+	opts *Options) Tab {
 	ti := textinput.NewModel()
 	ti.Placeholder = ""
 	ti.CharLimit = 255
@@ -71,12 +116,15 @@ func NewTab(client *gemini.Client, startURL string, scrollPos int, bs *bookmark.
 		client:    client,
 		history:   h,
 		input:     NewInput(),
-		viewport:  NewViewport(startURL, scrollPos, h),
+		editor:    NewEditor(id, opts.Editor),
+		viewport:  NewViewport(startURL, scrollPos, h, opts.DownloadDir),
 		message:   Message{},
 		bookmarks: bs,
-		specialPages: map[string]func(Tab) string{
-			homeURL: homeContent,
-			helpURL: helpContent,
+		opts:      opts,
+		specialPages: map[string]func(Tab, string) string{
+			homeURL:       homeContent,
+			helpURL:       helpContent,
+			identitiesURL: identitiesContent,
 		},
 	}
 }
@@ -92,10 +140,17 @@ func (tab Tab) Update(msg tea.Msg) (Tab, tea.Cmd) {
 		if errors.As(msg, &le) {
 			log.Print(le.Unwrap())
 			tab.viewport.loading = false
-			if errors.Is(msg, gemini.CertChanged) {
-				return tab.showMessage(fmt.Sprintf("The SSL certificate for %q has changed since last time.\n"+
-					"Would you like to see the page anyway?", le.URL),
-					le.URL, messageForceCert, true)
+			var cce *gemini.CertChangedError
+			if errors.As(msg, &cce) {
+				tab.message = NewChoiceMessage(describeCertChange(cce),
+					messageForceCert, fmt.Sprintf("%s %t %s", gemini.Fingerprint(cce.Cert), le.sensitive, le.URL),
+					choiceAcceptOnce, choiceTrust, choiceReject)
+				tab.mode = modeMessage
+				return tab, nil
+			}
+			if errors.Is(msg, errPageTooLarge) {
+				return tab.showMessage(fmt.Sprintf("%q is larger than %s.\nWould you like to download it instead?",
+					le.URL, formatSize(tab.opts.MaxPageSize)), le.URL, messageDownload, true)
 			}
 		}
 		if errors.Is(msg, context.Canceled) {
@@ -118,9 +173,42 @@ func (tab Tab) Update(msg tea.Msg) (Tab, tea.Cmd) {
 				}
 			}
 		case messageForceCert:
+			parts := strings.SplitN(msg.Payload, " ", 3)
+			trust := gemini.Trust{Fingerprint: parts[0], Permanent: msg.Choice == choiceTrust}
+			if (msg.Choice == choiceAcceptOnce || msg.Choice == choiceTrust) && parts[2] == tab.upload.url {
+				return tab.sendUpload(trust)
+			}
+			if msg.Choice == choiceAcceptOnce || msg.Choice == choiceTrust {
+				return tab.loadURL(parts[2], 0, true, 1, trust, parts[1] == "true")
+			}
+		case messageDownload:
+			if msg.Response {
+				return tab.downloadURL(msg.Payload)
+			}
+		case messageConfirmIdentity:
+			if msg.Response {
+				return tab.completeIdentityAction(msg.Payload, "")
+			}
+		case messageMoveBookmark:
+			if msg.Response {
+				parts := strings.SplitN(msg.Payload, " ", 2)
+				if err := tab.bookmarks.Replace(parts[0], parts[1]); err != nil {
+					log.Print(err)
+				}
+			}
+		case messageRedirect:
 			if msg.Response {
-				return tab.loadURL(msg.Payload, 0, true, 1, true)
+				return tab.followRedirect(tab.redirect.resp, tab.redirect.target)
 			}
+			tab.redirect = redirect{}
+			tab.movedBookmark = movedBookmark{}
+		case messageEditorError:
+			tab.mode = modeEditor // Keep editing the text that could not be edited externally
+		case messageRetry:
+			if msg.Choice == choiceRetry || msg.Choice == choiceRetryNow {
+				return tab.retry.load(tab)
+			}
+			tab.retry = retry{}
 		}
 	case ShowMessageEvent:
 		return tab.showMessage(msg.Message, msg.Payload, msg.Type, msg.WithConfirm)
@@ -131,9 +219,12 @@ func (tab Tab) Update(msg tea.Msg) (Tab, tea.Cmd) {
 		switch msg.Type {
 		case inputQuery:
 			url := fmt.Sprintf("%s?%s", msg.Payload, neturl.QueryEscape(msg.Value))
-			return tab.loadURL(url, 0, true, 1, false)
+			return tab.loadURL(url, 0, true, 1, gemini.Trust{}, false)
+		case inputSensitive:
+			url := fmt.Sprintf("%s?%s", msg.Payload, neturl.QueryEscape(msg.Value))
+			return tab.loadURL(url, 0, true, 1, gemini.Trust{}, true)
 		case inputNav:
-			return tab.loadURL(msg.Value, 0, true, 1, false)
+			return tab.loadURL(msg.Value, 0, true, 1, gemini.Trust{}, false)
 		case inputBookmark:
 			if err := tab.bookmarks.Add(msg.Payload, msg.Value); err != nil {
 				log.Print(err)
@@ -142,18 +233,53 @@ func (tab Tab) Update(msg tea.Msg) (Tab, tea.Cmd) {
 			if err := DownloadTo(tab.lastResponse, msg.Value); err != nil {
 				log.Print(err)
 			}
+		case inputIdentity:
+			return tab.useIdentity(strings.TrimSpace(msg.Value), msg.Payload)
+		case inputIdentityNew, inputIdentityRename, inputIdentityExport:
+			return tab.completeIdentityAction(msg.Payload, msg.Value)
+		case inputUploadToken:
+			return tab.editAndUpload(msg.Payload, msg.Value)
 		}
 	case ShowInputEvent:
 		return tab.showInput(msg.Message, msg.Value, msg.Payload, msg.Type)
+	case ShowEditorEvent:
+		tab.mode = modeEditor
+		tab.editor = tab.editor.Show(msg.Message, msg.Value, msg.Payload, msg.Type, tab.viewport.viewport.Height)
+		return tab, nil
+	case EditorEvent:
+		if msg.err != nil {
+			log.Print(msg.err)
+			switch {
+			case tab.mode == modeEditor && msg.payload == tab.editor.Payload:
+				return tab.showMessage(msg.err.Error(), "", messageEditorError, false)
+			case tab.mode == modePage: // Do not replace another message or input
+				return tab.showMessage(msg.err.Error(), "", messagePlain, false)
+			}
+			return tab, nil
+		}
+	case ShowOutlineEvent:
+		if len(tab.viewport.headings) == 0 {
+			return tab, nil // A page without headings has been loaded in the meantime
+		}
+		tab.mode = modeOutline
+		tab.outline = NewOutline(tab.viewport.title, tab.viewport.headings, tab.viewport.viewport.YOffset,
+			tab.viewport.viewport.Height)
+		return tab, nil
+	case OutlineEvent:
+		tab.mode = modePage
+		if msg.Selected {
+			tab.viewport.viewport.SetYOffset(msg.YPos)
+		}
+		return tab, nil
 	case LoadURLEvent:
-		return tab.loadURL(msg.URL, msg.ScrollPos, msg.AddHistory, 1, false)
+		return tab.loadURL(msg.URL, msg.ScrollPos, msg.AddHistory, 1, gemini.Trust{}, false)
 	case GoBackEvent:
 		if url, pos, ok := tab.history.Back(); ok {
-			return tab.loadURL(url, pos, false, 1, false)
+			return tab.loadURL(url, pos, false, 1, gemini.Trust{}, false)
 		}
 	case GoForwardEvent:
 		if url, pos, ok := tab.history.Forward(); ok {
-			return tab.loadURL(url, pos, false, 1, false)
+			return tab.loadURL(url, pos, false, 1, gemini.Trust{}, false)
 		}
 	case ToggleBookmarkEvent:
 		if tab.bookmarks.Contains(msg.URL) {
@@ -166,8 +292,18 @@ func (tab Tab) Update(msg tea.Msg) (Tab, tea.Cmd) {
 			log.Print(err)
 		}
 		return tab, nil
+	case UploadEvent:
+		return tab.startUpload()
+	case UploadEditedEvent:
+		return tab.handleUploadEdited(msg)
 	case ServerResponse:
 		return tab.handleResponse(msg)
+	case StreamEvent:
+		return tab.handleStream(msg)
+	case DownloadEvent:
+		return tab.handleDownload(msg)
+	case RetryEvent:
+		return tab.handleRetry(msg)
 	}
 
 	switch tab.mode {
@@ -177,6 +313,12 @@ func (tab Tab) Update(msg tea.Msg) (Tab, tea.Cmd) {
 	case modeMessage:
 		tab.message, cmd = tab.message.Update(msg)
 		cmds = append(cmds, cmd)
+	case modeEditor:
+		tab.editor, cmd = tab.editor.Update(msg)
+		cmds = append(cmds, cmd)
+	case modeOutline:
+		tab.outline, cmd = tab.outline.Update(msg)
+		cmds = append(cmds, cmd)
 	case modePage:
 		tab.viewport, cmd = tab.viewport.Update(msg)
 		cmds = append(cmds, cmd)
@@ -191,6 +333,10 @@ func (tab Tab) View() string {
 		return tab.input.View()
 	case modeMessage:
 		return tab.message.View()
+	case modeEditor:
+		return tab.editor.View()
+	case modeOutline:
+		return tab.outline.View()
 	default:
 		return tab.viewport.View()
 	}
@@ -208,7 +354,7 @@ func (tab Tab) showInput(msg, val, payload string, typ int) (Tab, tea.Cmd) {
 	return tab, textinput.Blink
 }
 
-func homeContent(tab Tab) string {
+func homeContent(tab Tab, url string) string {
 	var buf strings.Builder
 	fmt.Fprint(&buf, "# Home\n\n")
 	for _, bookmark := range builtinBookmarks {
@@ -222,7 +368,7 @@ func homeContent(tab Tab) string {
 	return buf.String()
 }
 
-func helpContent(tab Tab) string {
+func helpContent(tab Tab, url string) string {
 	s := `
 # Keys
 
@@ -239,7 +385,12 @@ Goto URL                g
 Download page           d
 Home                    H
 Bookmark                b
+Identities              i
 View source (in gvim)   e
+Edit and upload (Titan) U
+Collapse preformatted   p
+Scroll preformatted     < and >
+Outline of headings     o
 Scroll up               k
 Scroll down             j
 Scroll up (page)        Page up
@@ -262,6 +413,8 @@ type LoadError struct {
 	message string
 	tab     tabID
 	URL     string
+	// sensitive is set when the query of URL holds sensitive input
+	sensitive bool
 }
 
 func (le LoadError) Unwrap() error {
@@ -287,7 +440,29 @@ type GeminiResponse struct {
 	*gemini.Response
 	level     int
 	scrollPos int
+	addHist   bool
+	sensitive bool
 	tab       tabID
+	lines     <-chan streamChunk
+}
+
+// displayURL is the URL of the response to show and remember, without sensitive input
+func (gr GeminiResponse) displayURL() string {
+	if gr.sensitive {
+		return withoutQuery(gr.URL)
+	}
+	return gr.URL
+}
+
+// withoutQuery removes the query, which holds the input of the user, from url
+func withoutQuery(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery = ""
+	u.ForceQuery = false
+	return u.String()
 }
 
 func (gr GeminiResponse) Tab() tabID {
@@ -298,6 +473,81 @@ func (gr GeminiResponse) GetData() []byte {
 	return gr.Body
 }
 
+type streamChunk struct {
+	data []byte
+	err  error
+}
+
+// StreamEvent carries the lines of a streamed gemini body that arrived since the last event
+type StreamEvent struct {
+	resp GeminiResponse
+	data []byte
+	err  error
+	done bool
+}
+
+func (se StreamEvent) Tab() tabID {
+	return se.resp.tab
+}
+
+// streamInterval is how long lines are collected before the page is rendered again
+const streamInterval = time.Millisecond * 100
+
+// readLines reads body line by line in the background until it is exhausted or ctx is done
+// At most max+1 bytes are read, so a body without line breaks is still found to be larger than max.
+func readLines(ctx context.Context, body io.ReadCloser, max int64, done func()) <-chan streamChunk {
+	ch := make(chan streamChunk, 1024)
+	go func() {
+		<-ctx.Done()
+		body.Close()
+	}()
+	go func() {
+		defer close(ch)
+		defer done()
+		defer body.Close()
+		rdr := bufio.NewReader(io.LimitReader(body, max+1))
+		for {
+			line, err := rdr.ReadBytes('\n')
+			if len(line) > 0 {
+				select {
+				case ch <- streamChunk{data: line}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					ch <- streamChunk{err: err}
+				}
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func waitForLines(resp GeminiResponse) tea.Cmd {
+	return func() tea.Msg {
+		ev := StreamEvent{resp: resp}
+		chunk, ok := <-resp.lines
+		deadline := time.After(streamInterval)
+		for ok {
+			if chunk.err != nil {
+				ev.err = chunk.err
+				return ev
+			}
+			ev.data = append(ev.data, chunk.data...)
+			select {
+			case chunk, ok = <-resp.lines:
+			case <-deadline:
+				return ev
+			}
+		}
+		ev.done = true
+		return ev
+	}
+}
+
 type GopherResponse struct {
 	*gopher.Response
 	tab tabID
@@ -311,6 +561,160 @@ func (gr GopherResponse) Tab() tabID {
 	return gr.tab
 }
 
+type SpartanResponse struct {
+	*spartan.Response
+	level     int
+	scrollPos int
+	addHist   bool
+	tab       tabID
+}
+
+func (sr SpartanResponse) GetData() []byte {
+	return sr.Body
+}
+
+func (sr SpartanResponse) Tab() tabID {
+	return sr.tab
+}
+
+type NexResponse struct {
+	*nex.Response
+	tab tabID
+}
+
+func (nr NexResponse) GetData() []byte {
+	return nr.Data
+}
+
+func (nr NexResponse) Tab() tabID {
+	return nr.tab
+}
+
+// TextResponse is the response of protocols that serve plain text, like finger
+type TextResponse struct {
+	URL       string
+	Data      []byte
+	MediaType string
+	scrollPos int
+	tab       tabID
+}
+
+func (tr TextResponse) GetData() []byte {
+	return tr.Data
+}
+
+func (tr TextResponse) Tab() tabID {
+	return tr.tab
+}
+
+func (tab Tab) startDownload(body io.ReadCloser, url, mediaType string) tea.Msg {
+	// The query is left out of the log and the file name, it might hold sensitive input
+	url = withoutQuery(url)
+	f, err := createDownloadFile(suggestDownloadPath(tab.opts.DownloadDir, "", url, mediaType))
+	if err != nil {
+		body.Close()
+		return LoadError{err: err, message: "could not download URL", tab: tab.id, URL: url}
+	}
+	log.Printf("download %q to %q", url, f.Name())
+	return DownloadEvent{tab: tab.id, id: atomic.AddUint64(&downloadCount, 1), path: f.Name(),
+		updates: copyToFile(body, f)}
+}
+
+// downloadURL downloads url to the download dir without trying to display it
+func (tab Tab) downloadURL(url string) (Tab, tea.Cmd) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return tab.showMessage(err.Error(), "", messagePlain, false)
+	}
+	cmd := func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		switch u.Scheme {
+		case "gopher":
+			resp, err := gopher.StreamURL(ctx, *u)
+			if err != nil {
+				return LoadError{err: err, message: "could not download URL", tab: tab.id, URL: url}
+			}
+			return tab.startDownload(resp.Stream, url, "application/octet-stream")
+		case "file":
+			f, err := os.Open(filepath.FromSlash(u.Path))
+			if err != nil {
+				return LoadError{err: err, message: "could not download URL", tab: tab.id, URL: url}
+			}
+			return tab.startDownload(f, url, getMediaType(path.Ext(u.Path)))
+		case "nex":
+			resp, err := nex.StreamURL(ctx, *u)
+			if err != nil {
+				return LoadError{err: err, message: "could not download URL", tab: tab.id, URL: url}
+			}
+			return tab.startDownload(resp.Stream, url, mime.TypeByExtension(path.Ext(u.Path)))
+		case "finger":
+			resp, err := finger.StreamURL(ctx, *u)
+			if err != nil {
+				return LoadError{err: err, message: "could not download URL", tab: tab.id, URL: url}
+			}
+			return tab.startDownload(resp.Stream, url, "text/plain")
+		case "spartan":
+			resp, err := spartan.StreamURL(ctx, *u)
+			if err != nil {
+				return LoadError{err: err, message: "could not download URL", tab: tab.id, URL: url}
+			}
+			if resp.Stream == nil {
+				return LoadError{message: fmt.Sprintf("could not download URL: %d %s",
+					resp.Header.Status, resp.Header.Meta), tab: tab.id, URL: url}
+			}
+			return tab.startDownload(resp.Stream, url, resp.Header.Meta)
+		default: // gemini
+			resp, err := tab.client.StreamURL(ctx, *u, gemini.Trust{})
+			if err != nil {
+				return LoadError{err: err, message: "could not download URL", tab: tab.id, URL: url}
+			}
+			if resp.Stream == nil {
+				return LoadError{message: fmt.Sprintf("could not download URL: %d%d %s",
+					resp.Header.Status, resp.Header.StatusDetail, resp.Header.Meta), tab: tab.id, URL: url}
+			}
+			return tab.startDownload(resp.Stream, url, resp.Header.Meta)
+		}
+	}
+	return tab, cmd
+}
+
+func (tab Tab) handleDownload(ev DownloadEvent) (Tab, tea.Cmd) {
+	var downloads []DownloadEvent
+	var known bool
+	for _, d := range tab.downloads {
+		if d.id == ev.id {
+			known = true
+			continue
+		}
+		downloads = append(downloads, d)
+	}
+	if !known { // Download just started, so the page is not going to load
+		tab.viewport.loading = false
+	}
+	if !ev.update.done {
+		downloads = append(downloads, ev)
+	}
+	tab.downloads = downloads
+	tab.viewport.downloads = tab.downloadStatus()
+	if !ev.update.done {
+		return tab, waitForDownload(ev)
+	}
+	if ev.update.err != nil {
+		return tab.showMessage(ev.update.err.Error(), "", messagePlain, false)
+	}
+	tab.viewport.notice = fmt.Sprintf("saved %s", ev.path)
+	return tab, nil
+}
+
+func (tab Tab) downloadStatus() string {
+	var parts []string
+	for _, d := range tab.downloads {
+		parts = append(parts, fmt.Sprintf("⇣ %s %s", filepath.Base(d.path), formatSize(d.update.written)))
+	}
+	return strings.Join(parts, " ")
+}
+
 func DownloadTo(resp ServerResponse, path string) error {
 	err := os.WriteFile(path, resp.GetData(), 0644)
 	if err != nil {
@@ -320,23 +724,90 @@ func DownloadTo(resp ServerResponse, path string) error {
 }
 
 func (tab Tab) handleResponse(resp ServerResponse) (Tab, tea.Cmd) {
+	tab, cmd := tab.showResponse(resp)
+	if tab.movedBookmark.target != "" && tab.mode == modePage && !isRedirect(resp) {
+		return tab.offerBookmarkMove(cmd)
+	}
+	return tab, cmd
+}
+
+func (tab Tab) showResponse(resp ServerResponse) (Tab, tea.Cmd) {
+	if tab.mode == modeOutline {
+		tab.mode = modePage // The outline lists the headings of the previous page
+	}
 	switch resp := resp.(type) {
 	case GopherResponse:
 		tab.viewport.loading = false
 		tab.viewport = tab.viewport.SetGoperContent(resp.Data, resp.URL, resp.Type)
 		tab.lastResponse = resp
 		return tab, nil
-	case GeminiResponse:
+	case NexResponse:
+		tab.viewport.loading = false
+		tab.viewport = tab.viewport.SetNexContent(resp.Data, resp.URL, resp.Directory)
+		tab.lastResponse = resp
+		return tab, nil
+	case TextResponse:
+		tab.viewport.loading = false
+		tab.lastResponse = resp
+		body := strings.ReplaceAll(string(resp.Data), "\r\n", "\n")
+		tab.viewport = tab.viewport.SetGeminiContent(body, resp.URL, resp.MediaType, resp.scrollPos)
+		tab.viewport.verification = ""
+		return tab, nil
+	case SpartanResponse:
 		tab.viewport.loading = false
 		switch resp.Header.Status {
-		case 1:
-			return tab.showInput(resp.Header.Meta, "", resp.URL, inputQuery)
+		case 2:
+			tab.lastResponse = resp
+			body := string(resp.Body)
+			if strings.HasPrefix(resp.Header.Meta, "text/gemini") {
+				body = spartan.ToGemtext(body)
+			}
+			tab.viewport = tab.viewport.SetGeminiContent(body, resp.URL, resp.Header.Meta, resp.scrollPos)
+			tab.viewport.verification = ""
+			return tab, nil
 		case 3:
-			if resp.level > 5 {
+			if resp.level > maxRedirects {
 				return tab.showMessage("Too many redirects. Welcome to the Web from Hell.", "", messagePlain, false)
 			}
-			return tab.loadURL(resp.Header.Meta, resp.scrollPos, true, resp.level+1, false)
-		case 4, 5, 6:
+			u, err := neturl.Parse(resp.URL)
+			if err != nil {
+				return tab, nil
+			}
+			// Spartan redirects to a path on the same host
+			target, err := u.Parse(resp.Header.Meta)
+			if err != nil {
+				return tab.showMessage(fmt.Sprintf("%s redirects to an invalid path: %q", resp.URL, resp.Header.Meta),
+					"", messagePlain, false)
+			}
+			target.Scheme, target.Host = u.Scheme, u.Host
+			return tab.loadURL(target.String(), resp.scrollPos, resp.addHist, resp.level+1, gemini.Trust{}, false)
+		case 4:
+			return tab.showMessage(fmt.Sprintf("Error: %s", resp.Header.Meta), "", messagePlain, false)
+		default:
+			return tab.showMessage(fmt.Sprintf("Server error: %s", resp.Header.Meta), "", messagePlain, false)
+		}
+	case GeminiResponse:
+		tab.viewport.loading = false
+		if isUploaded(resp) {
+			tab.upload = upload{}
+		}
+		switch resp.Header.Status {
+		case 1:
+			if resp.Header.StatusDetail == 1 {
+				tab.mode = modeInput
+				tab.input = tab.input.ShowMasked(resp.Header.Meta, resp.URL, inputSensitive)
+				return tab, textinput.Blink
+			}
+			tab, cmd := tab.showInput(resp.Header.Meta, "", resp.URL, inputQuery)
+			tab.input.Multiline = true
+			return tab, cmd
+		case 3:
+			return tab.handleRedirect(resp)
+		case 6:
+			return tab.handleCertificateRequest(resp)
+		case 4:
+			return tab.handleTemporaryFailure(resp)
+		case 5:
 			return tab.showMessage(fmt.Sprintf("Error: %s", resp.Header.Meta), "", messagePlain, false)
 		case 2:
 			body, err := resp.GetBody()
@@ -345,7 +816,15 @@ func (tab Tab) handleResponse(resp ServerResponse) (Tab, tea.Cmd) {
 				return tab, nil
 			}
 			tab.lastResponse = resp
-			tab.viewport = tab.viewport.SetGeminiContent(body, resp.URL, resp.Header.Meta, resp.scrollPos)
+			tab.viewport = tab.viewport.SetGeminiContent(body, resp.displayURL(), resp.Header.Meta, resp.scrollPos)
+			tab.viewport.verification = resp.Verification.String()
+			if resp.CertRenewed {
+				tab.viewport.notice = "server certificate renewed"
+			}
+			if resp.lines != nil {
+				tab.viewport.loading = true
+				return tab, waitForLines(resp)
+			}
 			return tab, nil
 		default:
 			log.Print(resp.Header)
@@ -355,31 +834,183 @@ func (tab Tab) handleResponse(resp ServerResponse) (Tab, tea.Cmd) {
 	return tab, nil
 }
 
-func (tab Tab) loadURL(url string, scrollPos int, addHist bool, level int, skipVerify bool) (Tab, tea.Cmd) {
+func (tab Tab) handleStream(ev StreamEvent) (Tab, tea.Cmd) {
+	cur, ok := tab.lastResponse.(GeminiResponse)
+	if !ok || cur.Response != ev.resp.Response {
+		return tab, nil // Another page has been loaded in the meantime
+	}
+	if ev.err != nil {
+		log.Print(ev.err)
+	}
+	tab.viewport.loading = !ev.done
+	if int64(len(cur.Body)+len(ev.data)) > tab.opts.MaxPageSize {
+		tab.cancel()
+		tab.viewport.loading = false
+		if cur.addHist {
+			tab.history.RemoveCurrent(cur.displayURL())
+		}
+		return tab.showMessage(fmt.Sprintf("%q is larger than %s.\nWould you like to download it instead?",
+			cur.displayURL(), formatSize(tab.opts.MaxPageSize)), cur.URL, messageDownload, true)
+	}
+	if len(ev.data) > 0 {
+		cur.Body = append(cur.Body, ev.data...)
+		body, err := cur.GetBody()
+		if err != nil {
+			log.Print(err)
+			return tab, nil
+		}
+		// Keep the scroll position of the user, unless the page is not yet long enough
+		// for the position it should be restored to
+		scrollPos := tab.viewport.viewport.YOffset
+		if scrollPos < cur.scrollPos && tab.viewport.viewport.AtBottom() {
+			scrollPos = cur.scrollPos
+		}
+		tab.viewport = tab.viewport.SetGeminiContent(body, cur.displayURL(), cur.Header.Meta, scrollPos)
+	}
+	if ev.done {
+		return tab, nil
+	}
+	return tab, waitForLines(cur)
+}
+
+// describeCertChange compares the pinned and the new certificate of a host
+func describeCertChange(cce *gemini.CertChangedError) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "The certificate of %s has changed since it was trusted.\n"+
+		"This happens when it is renewed early, but it might also mean someone is intercepting the connection.\n\n",
+		cce.Host)
+	pinned := cce.Pinned
+	seen := "unknown"
+	if !pinned.FirstSeen.IsZero() {
+		seen = fmt.Sprintf("%s to %s", pinned.FirstSeen.Format("2006-01-02"), pinned.LastSeen.Format("2006-01-02"))
+	}
+	fmt.Fprintf(&buf, "Trusted certificate (seen %s)\n", seen)
+	describePin(&buf, pinned)
+	fmt.Fprint(&buf, "\nNew certificate\n")
+	describePin(&buf, gemini.NewPin(cce.Cert))
+	fmt.Fprint(&buf, "\nAccept the new certificate once, trust it from now on or reject it?")
+	return buf.String()
+}
+
+func describePin(buf *strings.Builder, pin gemini.Pin) {
+	unknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	validity := "unknown"
+	if !pin.NotAfter.IsZero() {
+		validity = fmt.Sprintf("until %s", pin.NotAfter.Format("2006-01-02"))
+		if !pin.NotBefore.IsZero() {
+			validity = fmt.Sprintf("%s to %s", pin.NotBefore.Format("2006-01-02"), pin.NotAfter.Format("2006-01-02"))
+		}
+		if time.Now().After(pin.NotAfter) {
+			validity += " (expired)"
+		}
+	}
+	fmt.Fprintf(buf, "  Fingerprint: %s\n", pin.Fingerprint)
+	fmt.Fprintf(buf, "  Subject:     %s\n", unknown(pin.Subject))
+	fmt.Fprintf(buf, "  Issuer:      %s\n", unknown(pin.Issuer))
+	fmt.Fprintf(buf, "  Names:       %s\n", unknown(strings.Join(pin.Names, ", ")))
+	fmt.Fprintf(buf, "  Valid:       %s\n", validity)
+	fmt.Fprintf(buf, "  Key:         %s\n", unknown(pin.KeyType))
+}
+
+func (tab Tab) handleCertificateRequest(resp GeminiResponse) (Tab, tea.Cmd) {
+	var reason string
+	if resp.Header.Meta != "" {
+		reason = fmt.Sprintf("\nThe server says: %s", resp.Header.Meta)
+	}
+	switch resp.Header.StatusDetail {
+	case 1:
+		if resp.Identity == "" {
+			return tab.showMessage("This page requires an authorized client certificate."+reason,
+				"", messagePlain, false)
+		}
+		return tab.showMessage(fmt.Sprintf("Your identity %q is not authorized to access this page.%s",
+			resp.Identity, reason), "", messagePlain, false)
+	case 2:
+		return tab.showMessage(fmt.Sprintf("The server did not accept the certificate of your identity %q. "+
+			"It might have expired or not be valid for this server, "+
+			"it can be regenerated on the identities page (i).%s", resp.Identity, reason),
+			"", messagePlain, false)
+	default:
+		m := "This page requires a client certificate."
+		if names := tab.client.Identities().Names(); len(names) > 0 {
+			m += fmt.Sprintf("\nExisting identities: %s", strings.Join(names, ", "))
+		}
+		m += reason + "\n\nName of the identity to use for this site (a new name creates one):"
+		return tab.showInput(m, "", resp.URL, inputIdentity)
+	}
+}
+
+// useIdentity binds the identity with the given name to the site of url and reloads url
+// The identity is created when it does not exist yet.
+func (tab Tab) useIdentity(name, url string) (Tab, tea.Cmd) {
+	u, err := neturl.Parse(url)
+	if err != nil || name == "" {
+		return tab, nil
+	}
+	ids := tab.client.Identities()
+	if ids.Get(name) == nil {
+		if _, err := ids.Create(name, tab.opts.certificateOptions(name)); err != nil {
+			return tab.showMessage(fmt.Sprintf("Could not create identity: %s", err), "", messagePlain, false)
+		}
+	}
+	if err := ids.Bind(name, gemini.HostPrefix(*u)); err != nil {
+		return tab.showMessage(err.Error(), "", messagePlain, false)
+	}
+	return tab.loadURL(url, 0, true, 1, gemini.Trust{}, false)
+}
+
+// loadURL loads url in the tab, sensitive is set when the query of url holds sensitive input like a password
+// The URL of a sensitive request is shown and remembered without its query.
+func (tab Tab) loadURL(url string, scrollPos int, addHist bool, level int, trust gemini.Trust,
+	sensitive bool) (Tab, tea.Cmd) {
 	if !strings.Contains(url, "://") {
 		url = fmt.Sprintf("gemini://%s", url)
 	}
-	specialF, isSpecial := tab.specialPages[url]
-	if !isSpecial && !strings.HasPrefix(url, "gemini://") && !strings.HasPrefix(url, "gopher://") {
+	if strings.HasPrefix(url, identitiesURL) {
+		if u, err := neturl.Parse(url); err == nil && u.Host != "" {
+			return tab.handleIdentityAction(u)
+		}
+	}
+	specialF, isSpecial := tab.specialPages[strings.SplitN(url, "?", 2)[0]]
+	if !isSpecial && !isInternal(url) {
 		tab.viewport.loading = false
 		return tab.showMessage(fmt.Sprintf("Open %q externally?", url), url, messageLoadExternal, true)
 	}
+	if u, err := neturl.Parse(url); err == nil && u.Scheme == "spartan" && spartan.IsPrompt(*u) {
+		tab.viewport.loading = false
+		u.ForceQuery = false
+		return tab.showInput(fmt.Sprintf("Input for %s", u.String()), "", u.String(), inputQuery)
+	}
 	if tab.cancel != nil {
 		tab.cancel()
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	ctx, cancel := context.WithCancel(context.Background())
 	tab.cancel = cancel
 	tab.viewport.loading = true
+	tab.viewport.notice = ""
+	tab.retry = retry{}
+	tab.redirect = redirect{}
+	if level == 1 {
+		tab.movedBookmark = movedBookmark{} // Only redirects keep offering to update a bookmark
+	}
 
 	cmd := func() tea.Msg {
 		tab.history.UpdateScroll(tab.viewport.viewport.YOffset)
-		defer cancel()
+		// Loading may take up to 30 seconds, a streamed body is read for as long as it takes
+		timeout := time.AfterFunc(time.Second*30, cancel)
+		defer timeout.Stop()
 
 		if isSpecial {
+			defer cancel()
 			if addHist {
 				tab.history.Add(url)
 			}
-			return GeminiResponse{Response: &gemini.Response{Body: []byte(specialF(tab)),
+			return GeminiResponse{Response: &gemini.Response{Body: []byte(specialF(tab, url)),
 				URL: url, Header: gemini.Header{Status: 2, Meta: "text/gemini"}}, level: level, tab: tab.id}
 		}
 
@@ -388,8 +1019,23 @@ func (tab Tab) loadURL(url string, scrollPos int, addHist bool, level int, skipV
 			return err
 		}
 		switch u.Scheme {
+		case "file":
+			defer cancel()
+			return tab.loadFile(ctx, u, scrollPos, addHist)
 		case "gopher":
-			resp, err := gopher.LoadURL(ctx, *u)
+			defer cancel()
+			resp, err := gopher.StreamURL(ctx, *u)
+			if err != nil {
+				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
+			}
+			if gopher.IsBinary(resp.Type) {
+				return tab.startDownload(resp.Stream, u.String(), "application/octet-stream")
+			}
+			resp.Data, err = readPage(ctx, resp.Stream, tab.opts.MaxPageSize)
+			resp.Stream = nil
+			if err := ctx.Err(); err != nil {
+				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
+			}
 			if err != nil {
 				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
 			}
@@ -397,18 +1043,88 @@ func (tab Tab) loadURL(url string, scrollPos int, addHist bool, level int, skipV
 				tab.history.Add(u.String())
 			}
 			return GopherResponse{Response: resp, tab: tab.id}
-		default: // gemini
-			resp, err := tab.client.LoadURL(ctx, *u, skipVerify)
-			if err := ctx.Err(); err != nil {
+		case "nex":
+			defer cancel()
+			resp, err := nex.StreamURL(ctx, *u)
+			if err != nil {
 				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
 			}
+			if mediaType := mime.TypeByExtension(path.Ext(u.Path)); !resp.Directory && mediaType != "" &&
+				!isText(mediaType) {
+				return tab.startDownload(resp.Stream, u.String(), mediaType)
+			}
+			resp.Data, err = readPage(ctx, resp.Stream, tab.opts.MaxPageSize)
+			resp.Stream = nil
 			if err != nil {
 				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
 			}
-			if addHist && resp.Header.Status == 2 {
+			if addHist {
 				tab.history.Add(u.String())
 			}
-			return GeminiResponse{Response: resp, level: level, tab: tab.id, scrollPos: scrollPos}
+			return NexResponse{Response: resp, tab: tab.id}
+		case "finger":
+			defer cancel()
+			resp, err := finger.StreamURL(ctx, *u)
+			if err != nil {
+				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
+			}
+			resp.Data, err = readPage(ctx, resp.Stream, tab.opts.MaxPageSize)
+			resp.Stream = nil
+			if err != nil {
+				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
+			}
+			if addHist {
+				tab.history.Add(resp.URL)
+			}
+			return TextResponse{URL: resp.URL, Data: resp.Data, MediaType: "text/plain", scrollPos: scrollPos,
+				tab: tab.id}
+		case "spartan":
+			defer cancel()
+			resp, err := spartan.StreamURL(ctx, *u)
+			if err != nil {
+				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
+			}
+			if resp.Stream != nil {
+				if !isText(resp.Header.Meta) {
+					return tab.startDownload(resp.Stream, resp.URL, resp.Header.Meta)
+				}
+				resp.Body, err = readPage(ctx, resp.Stream, tab.opts.MaxPageSize)
+				resp.Stream = nil
+				if err != nil {
+					return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String()}
+				}
+			}
+			if addHist && resp.Header.Status == 2 {
+				tab.history.Add(resp.URL)
+			}
+			return SpartanResponse{Response: resp, level: level, scrollPos: scrollPos, addHist: addHist, tab: tab.id}
+		default: // gemini
+			resp, err := tab.client.StreamURL(ctx, *u, trust)
+			if err := ctx.Err(); err != nil {
+				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String(),
+					sensitive: sensitive}
+			}
+			if err != nil {
+				cancel()
+				return LoadError{err: err, message: "could not load URL", tab: tab.id, URL: u.String(),
+					sensitive: sensitive}
+			}
+			gr := GeminiResponse{Response: resp, level: level, tab: tab.id, scrollPos: scrollPos, addHist: addHist,
+				sensitive: sensitive}
+			if resp.Stream != nil && !isText(resp.Header.Meta) {
+				cancel()
+				return tab.startDownload(resp.Stream, gr.displayURL(), resp.Header.Meta)
+			}
+			if addHist && resp.Header.Status == 2 {
+				tab.history.Add(gr.displayURL())
+			}
+			if resp.Stream != nil {
+				gr.lines = readLines(ctx, resp.Stream, tab.opts.MaxPageSize, cancel)
+				resp.Stream = nil
+			} else {
+				cancel()
+			}
+			return gr
 		}
 	}
 	return tab, tea.Batch(cmd, spinner.Tick)
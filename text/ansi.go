@@ -5,11 +5,20 @@ import "github.com/muesli/termenv"
 type Colo string
 
 const (
-	Ch1   Colo = "#FF0000" // red
-	Ch2   Colo = "#FFFF00" // yellow
-	Ch3   Colo = "#FF00FF" // fuchsia
-	Clink Colo = "#6495ED" // cornflowerblue
-	Ccode Colo = "#EEE8AA" // palegoldenrod
+	Ch1      Colo = "#FF0000" // red
+	Ch2      Colo = "#FFFF00" // yellow
+	Ch3      Colo = "#FF00FF" // fuchsia
+	Clink    Colo = "#6495ED" // cornflowerblue
+	Ccode    Colo = "#EEE8AA" // palegoldenrod
+	Clist    Colo = "#FFA500" // orange
+	Cquote   Colo = "#808080" // gray
+	Ccaption Colo = "#BDB76B" // darkkhaki
+	// Colors of highlighted source code, other code is Ccode
+	Ckeyword Colo = "#FF7F50" // coral
+	Cbuiltin Colo = "#87CEEB" // skyblue
+	Cstring  Colo = "#98FB98" // palegreen
+	Cnumber  Colo = "#DDA0DD" // plum
+	Ccomment Colo = "#808080" // gray
 )
 
 var colors = termenv.ColorProfile()
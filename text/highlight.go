@@ -0,0 +1,284 @@
+package text
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Span is a piece of text in a single color
+type Span struct {
+	Text  string
+	Color Colo
+}
+
+// stringType describes a string literal of a language
+type stringType struct {
+	delim     string
+	raw       bool // backslash does not escape
+	multiline bool
+}
+
+// language describes the tokens of a programming language to highlight
+type language struct {
+	keywords      []string
+	builtins      []string
+	lineComments  []string
+	blockComments [][2]string
+	strings       []stringType // longest delimiters first
+	variables     bool         // $name, ${name} and $1 are variables
+}
+
+var (
+	languages = map[string]*language{
+		"go": {
+			keywords: []string{"break", "case", "chan", "const", "continue", "default", "defer", "else",
+				"fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package",
+				"range", "return", "select", "struct", "switch", "type", "var"},
+			builtins: []string{"append", "bool", "byte", "cap", "close", "complex", "copy", "delete", "error",
+				"false", "float32", "float64", "int", "int8", "int16", "int32", "int64", "iota", "len", "make",
+				"new", "nil", "panic", "print", "println", "recover", "rune", "string", "true", "uint", "uint8",
+				"uint16", "uint32", "uint64", "uintptr"},
+			lineComments:  []string{"//"},
+			blockComments: [][2]string{{"/*", "*/"}},
+			strings:       []stringType{{delim: "`", raw: true, multiline: true}, {delim: `"`}, {delim: `'`}},
+		},
+		"python": {
+			keywords: []string{"and", "as", "assert", "async", "await", "break", "class", "continue", "def",
+				"del", "elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in", "is",
+				"lambda", "nonlocal", "not", "or", "pass", "raise", "return", "try", "while", "with", "yield"},
+			builtins: []string{"False", "None", "True", "bool", "dict", "float", "int", "len", "list", "open",
+				"print", "range", "self", "set", "str", "super", "tuple"},
+			lineComments: []string{"#"},
+			strings: []stringType{{delim: `"""`, multiline: true}, {delim: `'''`, multiline: true},
+				{delim: `"`}, {delim: `'`}},
+		},
+		"sh": {
+			keywords: []string{"case", "do", "done", "elif", "else", "esac", "export", "fi", "for", "function",
+				"if", "in", "local", "return", "then", "until", "while"},
+			builtins: []string{"cd", "echo", "exit", "printf", "read", "set", "shift", "source", "test",
+				"unset"},
+			lineComments: []string{"#"},
+			strings:      []stringType{{delim: `"`, multiline: true}, {delim: `'`, raw: true, multiline: true}},
+			variables:    true,
+		},
+		"json": {
+			builtins: []string{"false", "null", "true"},
+			strings:  []stringType{{delim: `"`}},
+		},
+		"javascript": {
+			keywords: []string{"async", "await", "break", "case", "catch", "class", "const", "continue",
+				"default", "delete", "do", "else", "export", "extends", "finally", "for", "from", "function",
+				"if", "import", "in", "instanceof", "interface", "let", "new", "of", "return", "switch", "throw",
+				"try", "type", "typeof", "var", "while", "yield"},
+			builtins:      []string{"console", "false", "null", "this", "true", "undefined"},
+			lineComments:  []string{"//"},
+			blockComments: [][2]string{{"/*", "*/"}},
+			strings:       []stringType{{delim: "`", multiline: true}, {delim: `"`}, {delim: `'`}},
+		},
+		"c": {
+			keywords: []string{"break", "case", "class", "const", "continue", "default", "do", "else", "enum",
+				"extern", "for", "goto", "if", "namespace", "private", "public", "return", "sizeof", "static",
+				"struct", "switch", "template", "typedef", "union", "using", "while", "#define", "#include"},
+			builtins: []string{"NULL", "bool", "char", "double", "false", "float", "int", "long", "nullptr",
+				"short", "size_t", "true", "unsigned", "void"},
+			lineComments:  []string{"//"},
+			blockComments: [][2]string{{"/*", "*/"}},
+			strings:       []stringType{{delim: `"`}, {delim: `'`}},
+		},
+		"rust": {
+			keywords: []string{"as", "async", "await", "break", "const", "continue", "crate", "else", "enum",
+				"fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move", "mut", "pub", "ref",
+				"return", "static", "struct", "trait", "type", "unsafe", "use", "where", "while"},
+			builtins: []string{"Box", "None", "Option", "Result", "Self", "Some", "String", "Vec", "bool",
+				"false", "i32", "i64", "self", "str", "true", "u8", "u32", "u64", "usize"},
+			lineComments:  []string{"//"},
+			blockComments: [][2]string{{"/*", "*/"}},
+			strings:       []stringType{{delim: `"`, multiline: true}},
+		},
+	}
+
+	languageAliases = map[string]string{
+		"golang": "go", "py": "python", "python3": "python", "bash": "sh", "shell": "sh", "zsh": "sh",
+		"console": "sh", "js": "javascript", "ts": "javascript", "typescript": "javascript",
+		"h": "c", "cpp": "c", "c++": "c", "rs": "rust",
+	}
+)
+
+// lookupLanguage returns the language named by the first word of alt text, or nil if it is not known
+func lookupLanguage(alt string) *language {
+	fields := strings.Fields(strings.ToLower(alt))
+	if len(fields) == 0 {
+		return nil
+	}
+	name := strings.Trim(fields[0], ".,:;()[]")
+	if alias, ok := languageAliases[name]; ok {
+		name = alias
+	}
+	return languages[name]
+}
+
+// Highlight splits the lines of source code into colored spans, with one slice of spans per line
+// The language is named by the first word of alt. It returns false if the language is not known or there are no lines.
+func Highlight(lines []string, alt string) ([][]Span, bool) {
+	lang := lookupLanguage(alt)
+	if lang == nil || len(lines) == 0 {
+		return nil, false
+	}
+	src := []rune(strings.Join(lines, "\n"))
+	var spans []Span
+	add := func(s string, color Colo) {
+		if n := len(spans); n > 0 && spans[n-1].Color == color {
+			spans[n-1].Text += s
+			return
+		}
+		spans = append(spans, Span{s, color})
+	}
+	for i := 0; i < len(src); {
+		n, color := lang.token(src, i)
+		add(string(src[i:i+n]), color)
+		i += n
+	}
+
+	// Split spans that run over several lines
+	result := make([][]Span, 1, len(lines))
+	for _, span := range spans {
+		parts := strings.Split(span.Text, "\n")
+		for j, part := range parts {
+			if j > 0 {
+				result = append(result, nil)
+			}
+			if part != "" {
+				result[len(result)-1] = append(result[len(result)-1], Span{part, span.Color})
+			}
+		}
+	}
+	return result, true
+}
+
+// token returns the length and color of the token at position i of src
+func (lang *language) token(src []rune, i int) (int, Colo) {
+	for _, bc := range lang.blockComments {
+		if hasPrefix(src, i, bc[0]) {
+			return untilDelim(src, i, len([]rune(bc[0])), bc[1], true, true), Ccomment
+		}
+	}
+	for _, lc := range lang.lineComments {
+		// A shell comment starts a word, like in echo a#b
+		if hasPrefix(src, i, lc) && (lc != "#" || i == 0 || unicode.IsSpace(src[i-1])) {
+			return untilDelim(src, i, 0, "\n", true, false), Ccomment
+		}
+	}
+	for _, st := range lang.strings {
+		if hasPrefix(src, i, st.delim) {
+			return untilDelim(src, i, len([]rune(st.delim)), st.delim, st.raw, st.multiline), Cstring
+		}
+	}
+	r := src[i]
+	prevIdent := i > 0 && isIdent(src[i-1])
+	switch {
+	case lang.variables && r == '$' && i+1 < len(src):
+		if src[i+1] == '{' {
+			return untilDelim(src, i, 2, "}", true, false), Cbuiltin
+		}
+		n := 1
+		for i+n < len(src) && isIdent(src[i+n]) {
+			n++
+		}
+		if n == 1 && i+1 < len(src) && strings.ContainsRune("?#@*!", src[i+1]) {
+			n = 2
+		}
+		return n, Cbuiltin
+	case unicode.IsDigit(r) && !prevIdent:
+		n := 1
+		for i+n < len(src) && (isIdent(src[i+n]) || src[i+n] == '.') {
+			n++
+		}
+		return n, Cnumber
+	case isIdent(r) || r == '#':
+		n := 1
+		for i+n < len(src) && isIdent(src[i+n]) {
+			n++
+		}
+		word := string(src[i : i+n])
+		switch {
+		case prevIdent:
+			return n, Ccode
+		case contains(lang.keywords, word):
+			return n, Ckeyword
+		case contains(lang.builtins, word):
+			return n, Cbuiltin
+		}
+		return n, Ccode
+	}
+	return 1, Ccode
+}
+
+// untilDelim returns the length of the token at i that ends with delim after skipping the first skip runes
+// A token that is not closed ends at the end of the line, or of src if it can span lines.
+func untilDelim(src []rune, i, skip int, delim string, raw, multiline bool) int {
+	d := []rune(delim)
+	for j := i + skip; j < len(src); j++ {
+		switch {
+		case !raw && src[j] == '\\':
+			j++
+		case hasPrefix(src, j, delim):
+			return j + len(d) - i
+		case src[j] == '\n' && !multiline:
+			return j - i
+		}
+	}
+	return len(src) - i
+}
+
+func hasPrefix(src []rune, i int, prefix string) bool {
+	p := []rune(prefix)
+	if i+len(p) > len(src) {
+		return false
+	}
+	for j, r := range p {
+		if src[i+j] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// CutSpans returns the width columns of a line of spans that start at column offset
+// A width of 0 or less does not limit the width.
+func CutSpans(spans []Span, offset, width int) []Span {
+	var result []Span
+	col := 0
+	for _, span := range spans {
+		runes := []rune(span.Text)
+		start, end := col, col+len(runes)
+		col = end
+		if end <= offset {
+			continue
+		}
+		if width > 0 && start >= offset+width {
+			break
+		}
+		from, to := 0, len(runes)
+		if start < offset {
+			from = offset - start
+		}
+		if width > 0 && end > offset+width {
+			to = offset + width - start
+		}
+		result = append(result, Span{string(runes[from:to]), span.Color})
+	}
+	return result
+}
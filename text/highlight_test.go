@@ -0,0 +1,49 @@
+package text
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		alt   string
+		ok    bool
+	}{
+		{name: "empty", lines: nil, alt: "go"},
+		{name: "unknown language", lines: []string{"x := 1"}, alt: "cobol"},
+		{name: "single line", lines: []string{`fmt.Println("hi") // greet`}, alt: "go", ok: true},
+		{name: "empty line", lines: []string{""}, alt: "go", ok: true},
+		{name: "empty lines", lines: []string{"", "", ""}, alt: "python", ok: true},
+		{name: "unclosed string", lines: []string{`s := "abc`, "x := 1"}, alt: "go", ok: true},
+		{name: "unclosed multiline string", lines: []string{"s := `abc", "def"}, alt: "go", ok: true},
+		{name: "unclosed block comment", lines: []string{"/* a", "b"}, alt: "c", ok: true},
+		{name: "trailing escape", lines: []string{`"abc\`}, alt: "json", ok: true},
+		{name: "lone variable sign", lines: []string{"echo $"}, alt: "sh", ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := Highlight(tt.lines, tt.alt)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if len(result) != len(tt.lines) {
+				t.Fatalf("got %d lines, want %d", len(result), len(tt.lines))
+			}
+			for i, spans := range result {
+				var buf strings.Builder
+				for _, span := range spans {
+					buf.WriteString(span.Text)
+				}
+				if buf.String() != tt.lines[i] {
+					t.Errorf("line %d is %q, want %q", i, buf.String(), tt.lines[i])
+				}
+			}
+		})
+	}
+}
@@ -62,11 +62,18 @@ func RuneCount(s string) int {
 }
 
 func ApplyMargin(input string, availableWidth, textWidth int) string {
-	margin := (availableWidth - textWidth) / 2
-	indent := strings.Repeat(" ", margin)
+	indent := strings.Repeat(" ", Margin(availableWidth, textWidth))
 	lines := strings.Split(input, "\n")
 	for i, line := range lines {
 		lines[i] = indent + line
 	}
 	return strings.Join(lines, "\n")
 }
+
+// Margin returns the number of columns left of text centered in availableWidth
+func Margin(availableWidth, textWidth int) int {
+	if availableWidth < textWidth {
+		return 0
+	}
+	return (availableWidth - textWidth) / 2
+}
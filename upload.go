@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"git.sr.ht/~rafael/gembro/gemini"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// startUpload asks for the token to upload a changed version of the current page with Titan
+func (tab Tab) startUpload() (Tab, tea.Cmd) {
+	resp, ok := tab.lastResponse.(GeminiResponse)
+	if !ok || !strings.HasPrefix(resp.URL, "gemini://") || !isText(resp.Header.Meta) {
+		return tab.showMessage("Only text pages of Gemini capsules can be edited and uploaded with Titan.",
+			"", messagePlain, false)
+	}
+	u, err := neturl.Parse(resp.displayURL())
+	if err != nil {
+		return tab.showMessage(fmt.Sprintf("Invalid URL: %s", err), "", messagePlain, false)
+	}
+	titanURL := gemini.TitanURL(*u)
+	tab.mode = modeInput
+	m := fmt.Sprintf("Edit and upload to %s", titanURL.String())
+	if tab.upload.url == titanURL.String() && tab.upload.text != "" {
+		m += "\nYour edits of the upload that failed are edited again."
+	}
+	tab.input = tab.input.ShowMasked(m+"\nToken (leave empty if none)", titanURL.String(), inputUploadToken)
+	return tab, textinput.Blink
+}
+
+// upload is a page edited to be uploaded with Titan
+// The edited text is kept until the upload succeeds, so a failed upload can be tried again without losing it.
+type upload struct {
+	url       string // titan URL to upload to
+	token     string
+	mediaType string
+	source    string // source of the page before editing
+	text      string // edited source
+}
+
+// UploadEditedEvent is sent when the page to upload has been edited in the external editor
+type UploadEditedEvent struct {
+	tab  tabID
+	url  string
+	text string
+	err  error
+}
+
+func (ue UploadEditedEvent) Tab() tabID {
+	return ue.tab
+}
+
+// editAndUpload edits the source of the current page in the external editor and uploads the result to titanURL
+// The edits of a failed upload to the same URL are edited again instead.
+func (tab Tab) editAndUpload(titanURL, token string) (Tab, tea.Cmd) {
+	resp, ok := tab.lastResponse.(GeminiResponse)
+	if !ok {
+		return tab, nil
+	}
+	up := upload{url: titanURL, token: token, source: string(resp.Body),
+		mediaType: strings.TrimSpace(strings.Split(resp.Header.Meta, ";")[0])}
+	text := up.source
+	if tab.upload.url == titanURL && tab.upload.text != "" {
+		up.text = tab.upload.text
+		text = up.text
+	}
+	tab.upload = up
+	return tab, editExternally(tab.opts.Editor, text, func(edited string, err error) tea.Msg {
+		return UploadEditedEvent{tab: tab.id, url: titanURL, text: edited, err: err}
+	})
+}
+
+// handleUploadEdited uploads the edited page
+func (tab Tab) handleUploadEdited(ev UploadEditedEvent) (Tab, tea.Cmd) {
+	if ev.url != tab.upload.url {
+		return tab, nil // Another upload has been started in the meantime
+	}
+	if ev.err != nil {
+		return tab.showMessage(fmt.Sprintf("could not edit page: %s", ev.err), "", messagePlain, false)
+	}
+	if ev.text == tab.upload.source {
+		tab.upload = upload{}
+		return tab.showMessage("The page was not changed, nothing was uploaded.", "", messagePlain, false)
+	}
+	tab.upload.text = ev.text
+	return tab.sendUpload(gemini.Trust{})
+}
+
+// sendUpload uploads the edited page, trust is used when the certificate of the server has changed
+func (tab Tab) sendUpload(trust gemini.Trust) (Tab, tea.Cmd) {
+	up := tab.upload
+	if up.text == "" {
+		return tab, nil
+	}
+	tab.viewport.loading = true
+	return tab, func() tea.Msg {
+		u, err := neturl.Parse(up.url)
+		if err != nil {
+			return LoadError{err: err, message: "could not upload page", tab: tab.id, URL: up.url}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		timeout := time.AfterFunc(time.Second*30, cancel)
+		defer timeout.Stop()
+		data := gemini.Upload{Data: strings.NewReader(up.text), Size: int64(len(up.text)),
+			MediaType: up.mediaType, Token: up.token}
+		uresp, err := tab.client.Upload(ctx, *u, data, trust)
+		if err != nil {
+			cancel()
+			return LoadError{err: err, message: "could not upload page", tab: tab.id, URL: up.url}
+		}
+		gr := GeminiResponse{Response: uresp, level: 1, tab: tab.id, addHist: true}
+		if uresp.Stream != nil {
+			gr.lines = readLines(ctx, uresp.Stream, tab.opts.MaxPageSize, cancel)
+			uresp.Stream = nil
+		} else {
+			cancel()
+		}
+		return gr
+	}
+}
+
+// isUploaded reports whether resp is the reply to an upload that succeeded
+func isUploaded(resp GeminiResponse) bool {
+	return strings.HasPrefix(resp.URL, "titan:") && (resp.Header.Status == 2 || resp.Header.Status == 3)
+}
@@ -15,12 +15,17 @@ import (
 
 var once sync.Once
 
-func getExt(mediaType string) string {
+// addMediaTypes registers the media types missing from the system tables
+func addMediaTypes() {
 	once.Do(func() {
 		if err := mime.AddExtensionType(".gmi", "text/gemini"); err != nil {
 			log.Print(err)
 		}
 	})
+}
+
+func getExt(mediaType string) string {
+	addMediaTypes()
 	exts, err := mime.ExtensionsByType(mediaType)
 	if err != nil {
 		log.Print(err)
@@ -31,12 +36,51 @@ func getExt(mediaType string) string {
 	return ""
 }
 
-func suggestDownloadPath(title, url, mediaType string) string {
+// getMediaType returns the media type of files with the extension ext, or "" if it is unknown
+func getMediaType(ext string) string {
+	addMediaTypes()
+	return mime.TypeByExtension(ext)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether content of mediaType can be rendered as a page
+func isText(mediaType string) bool {
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+	return mediaType == "" || strings.HasPrefix(mediaType, "text/")
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// defaultDownloadDir returns ~/Downloads if it exists, the home dir otherwise
+func defaultDownloadDir() string {
 	hpath, _ := os.UserHomeDir()
 	downloadDir := filepath.Join(hpath, "Downloads")
 	if _, err := os.Stat(downloadDir); err == nil { // Dir exists
-		hpath = downloadDir
+		return downloadDir
 	}
+	return hpath
+}
+
+func suggestDownloadPath(hpath, title, url, mediaType string) string {
 	name := title
 	if name == "" {
 		name = path.Base(url)
@@ -85,3 +129,16 @@ func osOpenURL(url string) error {
 	}
 	return nil
 }
+
+// defaultEditor returns the editor of the user, which is given the terminal while it runs
+func defaultEditor() string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if e := os.Getenv(env); e != "" {
+			return e
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
@@ -10,6 +10,7 @@ import (
 	"git.sr.ht/~rafael/gembro/gemini"
 	"git.sr.ht/~rafael/gembro/gopher"
 	"git.sr.ht/~rafael/gembro/internal/history"
+	"git.sr.ht/~rafael/gembro/nex"
 	"git.sr.ht/~rafael/gembro/text"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/viewport"
@@ -27,6 +28,7 @@ const (
 	buttonQuit     = "Quit"
 	buttonHelp     = "Help"
 	buttonEdit     = "Edit"
+	buttonIdentity = "Identities"
 )
 
 type Viewport struct {
@@ -43,9 +45,24 @@ type Viewport struct {
 	lastEvent tea.MouseEventType
 	history   *history.History
 	digits    string
+
+	downloadDir string
+	downloads   string // progress of running downloads
+	notice      string
+	// verification tells how the certificate of the server of the current page was verified
+	verification string
+
+	// document is the current gemtext page, it is rendered again when render changes
+	document *gemini.Document
+	baseURL  neturl.URL
+	render   gemini.RenderOptions
+	headings []gemini.Heading
 }
 
-func NewViewport(startURL string, scrollPos int, h *history.History) Viewport {
+// preScrollStep is the number of columns preformatted text is scrolled horizontally per key press
+const preScrollStep = 8
+
+func NewViewport(startURL string, scrollPos int, h *history.History, downloadDir string) Viewport {
 	s := spinner.NewModel()
 	s.Spinner = spinner.Points
 	// footerLead := "Back (RMB) Forward (->) Home (h) Bookmark (b) Download (d) Close tab (q) Quit (ctrl+c) "
@@ -54,12 +71,14 @@ func NewViewport(startURL string, scrollPos int, h *history.History) Viewport {
 		startScroll: scrollPos,
 		spinner:     s,
 		history:     h,
+		downloadDir: downloadDir,
 		footer:      NewFooter(buttonBack, buttonFwd, buttonHome, buttonBookmark, buttonDownload, buttonHelp, buttonQuit),
 	}
 }
 
 func (v Viewport) SetGoperContent(data []byte, url string, typ byte) Viewport {
 	v.URL = url
+	v.verification = ""
 	switch typ {
 	case 'h':
 		v.MediaType = "text/html"
@@ -69,6 +88,8 @@ func (v Viewport) SetGoperContent(data []byte, url string, typ byte) Viewport {
 		v.MediaType = "text/plain"
 	}
 	v.title = "Gopher"
+	v.document = nil
+	v.headings = nil
 	var content string
 	content, v.links = gopher.ToANSI(data, typ)
 	content = text.ApplyMargin(content, v.viewport.Width, gopher.TextWidth)
@@ -77,15 +98,41 @@ func (v Viewport) SetGoperContent(data []byte, url string, typ byte) Viewport {
 	return v
 }
 
+func (v Viewport) SetNexContent(data []byte, url string, directory bool) Viewport {
+	v.URL = url
+	v.verification = ""
+	v.MediaType = "text/plain"
+	v.title = "Nex"
+	v.document = nil
+	v.headings = nil
+	u, _ := neturl.Parse(url)
+	var content string
+	content, v.links = nex.ToANSI(data, directory, *u)
+	content = text.ApplyMargin(content, v.viewport.Width, nex.TextWidth)
+	v.viewport.SetContent(content)
+	v.viewport.GotoTop()
+	return v
+}
+
 func (v Viewport) SetGeminiContent(content, url, mediaType string, scrollPos int) Viewport {
 	v.URL = url
 	v.MediaType = mediaType
 	u, _ := neturl.Parse(url)
 	var s string
+	v.document = nil
+	v.headings = nil
 
 	switch mediaType := strings.Split(mediaType, ";")[0]; mediaType {
 	case "text/gemini":
-		s, v.links, v.title = gemini.ToANSI(content, v.viewport.Width, *u)
+		doc := gemini.Parse(content)
+		v.document = &doc
+		v.baseURL = *u
+		v.render.PreOffset = 0
+		s, v.links, v.headings = doc.ToANSI(v.viewport.Width, *u, v.render)
+		v.title = doc.Title()
+		if v.title == "" {
+			v.title = url
+		}
 	default:
 		if strings.HasPrefix(mediaType, "text/") {
 			s = text.ApplyMargin(content, v.viewport.Width, gemini.TextWidth)
@@ -101,6 +148,35 @@ func (v Viewport) SetGeminiContent(content, url, mediaType string, scrollPos int
 	return v
 }
 
+// rerender renders the current gemtext page again with changed render options
+func (v Viewport) rerender() Viewport {
+	if v.document == nil {
+		return v
+	}
+	var s string
+	s, v.links, v.headings = v.document.ToANSI(v.viewport.Width, v.baseURL, v.render)
+	yOffset := v.viewport.YOffset
+	v.viewport.SetContent(s)
+	v.viewport.SetYOffset(yOffset)
+	return v
+}
+
+// preformattedWidth returns the width of the widest preformatted line of the current page
+func (v Viewport) preformattedWidth() int {
+	var width int
+	if v.document == nil {
+		return width
+	}
+	for _, line := range v.document.Lines {
+		for _, l := range line.Lines {
+			if n := text.RuneCount(l); n > width {
+				width = n
+			}
+		}
+	}
+	return width
+}
+
 func (v Viewport) Update(msg tea.Msg) (Viewport, tea.Cmd) {
 	var cmds []tea.Cmd
 	var cmd tea.Cmd
@@ -122,8 +198,12 @@ func (v Viewport) Update(msg tea.Msg) (Viewport, tea.Cmd) {
 			return v, fireEvent(LoadURLEvent{URL: startURL, ScrollPos: v.startScroll,
 				AddHistory: hist != startURL})
 		} else {
+			resized := v.viewport.Width != msg.Width
 			v.viewport.Width = msg.Width
 			v.viewport.Height = msg.Height - verticalMargins
+			if resized {
+				v = v.rerender()
+			}
 		}
 	case tea.MouseMsg:
 		v, cmd = v.handleMouse(msg)
@@ -144,6 +224,30 @@ func (v Viewport) Update(msg tea.Msg) (Viewport, tea.Cmd) {
 			return v, v.handleButtonClick(buttonHelp)
 		case "e":
 			return v, v.handleButtonClick(buttonEdit)
+		case "U": // u scrolls up half a page
+			return v, fireEvent(UploadEvent{})
+		case "i":
+			return v, v.handleButtonClick(buttonIdentity)
+		case "o":
+			if len(v.headings) == 0 {
+				return v, nil
+			}
+			return v, fireEvent(ShowOutlineEvent{})
+		case "p":
+			v.render.Collapse = !v.render.Collapse
+			return v.rerender(), nil
+		case ">":
+			if v.render.PreOffset+preScrollStep < v.preformattedWidth() {
+				v.render.PreOffset += preScrollStep
+				return v.rerender(), nil
+			}
+			return v, nil
+		case "<":
+			if v.render.PreOffset > 0 {
+				v.render.PreOffset -= preScrollStep
+				return v.rerender(), nil
+			}
+			return v, nil
 		case "left", "h":
 			return v, v.handleButtonClick(buttonBack)
 		case "right", "l":
@@ -205,11 +309,11 @@ func (v Viewport) handleButtonClick(btn string) tea.Cmd {
 		return fireEvent(ToggleBookmarkEvent{URL: v.URL, Title: v.title})
 	case buttonDownload:
 		return fireEvent(ShowInputEvent{Message: "Download to",
-			Value: suggestDownloadPath(v.title, v.URL, v.MediaType),
+			Value: suggestDownloadPath(v.downloadDir, v.title, v.URL, v.MediaType),
 			Type:  inputDownloadSrc})
 	case buttonGoto:
 		var val string
-		if cur, _ := v.history.Current(); cur != homeURL && cur != helpURL {
+		if cur, _ := v.history.Current(); cur != homeURL && cur != helpURL && !strings.HasPrefix(cur, identitiesURL) {
 			val = cur
 		}
 		return fireEvent(ShowInputEvent{Message: "Go to", Type: inputNav, Payload: "", Value: val})
@@ -217,6 +321,11 @@ func (v Viewport) handleButtonClick(btn string) tea.Cmd {
 		return fireEvent(CloseCurrentTabEvent{})
 	case buttonEdit:
 		return fireEvent(EditSourceEvent{})
+	case buttonIdentity:
+		if v.URL == identitiesURL {
+			return fireEvent(GoBackEvent{})
+		}
+		return fireEvent(LoadURLEvent{URL: identitiesPageURL(v.URL), AddHistory: true})
 	case buttonQuit:
 		return fireEvent(QuitEvent{})
 	default:
@@ -230,14 +339,25 @@ func (v Viewport) View() string {
 	}
 
 	var headerTail string
+	if v.verification != "" {
+		headerTail = fmt.Sprintf(" :: %s", v.verification)
+	}
 	if v.loading {
-		headerTail = fmt.Sprintf(" :: %s", v.spinner.View())
+		headerTail = fmt.Sprintf("%s :: %s", headerTail, v.spinner.View())
+	}
+	if v.downloads != "" {
+		headerTail = fmt.Sprintf("%s :: %s", headerTail, v.downloads)
+	} else if v.notice != "" {
+		headerTail = fmt.Sprintf("%s :: %s", headerTail, v.notice)
 	}
 	if v.digits != "" {
 		headerTail = fmt.Sprintf("%s :: %s", headerTail, v.digits)
 	}
 	header := fmt.Sprintf("%s%s ", v.URL, headerTail)
 	gapSize := v.viewport.Width - text.RuneCount(header)
+	if gapSize < 0 {
+		gapSize = 0
+	}
 	header += strings.Repeat("─", gapSize)
 
 	footer := fmt.Sprintf(" %3.f%%", v.viewport.ScrollPercent()*100)